@@ -1,24 +1,62 @@
 package main
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 
+	"github.com/archway-network/endpoint-controller/pkg/blockchain"
 	"github.com/archway-network/endpoint-controller/pkg/controller"
+	"github.com/archway-network/endpoint-controller/pkg/metrics"
 	"github.com/archway-network/endpoint-controller/pkg/utils"
 )
 
 const (
-	defaultSyncPeriod = "30"
-	defaultBlockMiss  = "6"
+	defaultSyncPeriod   = "30"
+	defaultBlockMiss    = "6"
+	defaultWorkers      = "2"
+	defaultEndpointMode = controller.EndpointModeEndpoints
+	defaultMetricsAddr  = ":8080"
+	defaultMaxBlockAge  = "0"
+	defaultStallChecks  = "0"
+	defaultMinBlockRate = "0"
+
+	defaultReferenceHeight = blockchain.ReferenceMax
+	defaultReferenceQuorum = "2"
+	defaultMaxBlocksAhead  = "0"
+
+	defaultCheckConcurrency = "32"
+	defaultCheckTimeout     = "25"
+	defaultShutdownGrace    = "20"
+	defaultRiseChecks       = "1"
+	defaultFallChecks       = "1"
+	defaultMinHealthy       = "0"
+	defaultMinHealthyMode   = controller.MinHealthyModeKeep
+
+	defaultLeaseName      = "endpoint-controller"
+	defaultLeaseNamespace = "default"
+	defaultLeaseDuration  = "15"
+	defaultRenewDeadline  = "10"
+	defaultRetryPeriod    = "2"
 )
 
 func main() {
 	var syncPeriod time.Duration
 	var blockMiss int
+	var workers int
 
 	// Get environment variables, if not use defaults
 	syncPeriodEnv, err := utils.GetEnv("SYNC_PERIOD", defaultSyncPeriod)
@@ -33,6 +71,125 @@ func main() {
 		klog.Fatal(err)
 	}
 
+	workers, err = utils.GetEnv("WORKERS", defaultWorkers)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	endpointMode := utils.GetEnvString("ENDPOINT_MODE", defaultEndpointMode)
+	switch endpointMode {
+	case controller.EndpointModeEndpoints, controller.EndpointModeEndpointSlices, controller.EndpointModeBoth:
+	default:
+		klog.Fatalf("invalid ENDPOINT_MODE %q", endpointMode)
+	}
+
+	rpc := blockchain.RPCConfig{
+		Scheme: utils.GetEnvString("RPC_SCHEME", ""),
+		Port:   utils.GetEnvString("RPC_PORT", ""),
+		Path:   utils.GetEnvString("RPC_PATH", ""),
+	}
+	switch rpc.Scheme {
+	case "", "http", "https":
+	default:
+		klog.Fatalf("invalid RPC_SCHEME %q", rpc.Scheme)
+	}
+
+	maxBlockAge, err := utils.GetEnv("MAX_BLOCK_AGE", defaultMaxBlockAge)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	stallChecks, err := utils.GetEnv("STALL_CHECKS", defaultStallChecks)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	minBlockRate, err := strconv.ParseFloat(utils.GetEnvString("MIN_BLOCK_RATE", defaultMinBlockRate), 64)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	rejectValidators, err := utils.GetEnvBool("REJECT_VALIDATORS", false)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	forkCheck, err := utils.GetEnvBool("FORK_CHECK", false)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	referenceHeight := utils.GetEnvString("REFERENCE_HEIGHT", defaultReferenceHeight)
+	switch referenceHeight {
+	case blockchain.ReferenceMax, blockchain.ReferenceMedian, blockchain.ReferenceQuorum, blockchain.ReferenceTrimmedMax:
+	default:
+		klog.Fatalf("invalid REFERENCE_HEIGHT %q", referenceHeight)
+	}
+
+	referenceQuorum, err := utils.GetEnv("REFERENCE_QUORUM", defaultReferenceQuorum)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	maxBlocksAhead, err := utils.GetEnv("MAX_BLOCKS_AHEAD", defaultMaxBlocksAhead)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	checkConcurrency, err := utils.GetEnv("CHECK_CONCURRENCY", defaultCheckConcurrency)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	checkTimeout, err := utils.GetEnv("CHECK_TIMEOUT", defaultCheckTimeout)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	riseChecks, err := utils.GetEnv("RISE_CHECKS", defaultRiseChecks)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	fallChecks, err := utils.GetEnv("FALL_CHECKS", defaultFallChecks)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	minHealthy, err := controller.ParseMinHealthy(utils.GetEnvString("MIN_HEALTHY", defaultMinHealthy))
+	if err != nil {
+		klog.Fatalf("invalid MIN_HEALTHY: %s", err)
+	}
+
+	minHealthyMode := utils.GetEnvString("MIN_HEALTHY_MODE", defaultMinHealthyMode)
+	switch minHealthyMode {
+	case controller.MinHealthyModeKeep, controller.MinHealthyModeAll:
+	default:
+		klog.Fatalf("invalid MIN_HEALTHY_MODE %q", minHealthyMode)
+	}
+
+	shutdownGrace, err := utils.GetEnv("SHUTDOWN_GRACE_PERIOD", defaultShutdownGrace)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	leaderElect, err := utils.GetEnvBool("LEADER_ELECT", false)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	// serve the controller metrics
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	metricsAddr := utils.GetEnvString("METRICS_ADDR", defaultMetricsAddr)
+	go func() {
+		klog.Infof("Serving metrics on %s/metrics", metricsAddr)
+		klog.Fatal(metrics.ListenAndServe(metricsAddr, registry))
+	}()
+
 	// create the Kubernetes client object using the service account
 	config, err := rest.InClusterConfig()
 	if err != nil {
@@ -44,13 +201,93 @@ func main() {
 		klog.Fatal(err.Error())
 	}
 
+	// post events on the services
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartStructuredLogging(0)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: clientset.CoreV1().Events(""),
+	})
+	defer broadcaster.Shutdown()
+
 	// create a controller to handle service events
 	c := controller.Controller{
 		Clientset: clientset,
 		Resync:    syncPeriod,
 		BlockMiss: blockMiss,
+		Workers:   workers,
+
+		ShutdownGracePeriod: time.Duration(shutdownGrace) * time.Second,
+
+		EndpointMode:     endpointMode,
+		RPC:              rpc,
+		MaxBlockAge:      time.Duration(maxBlockAge) * time.Second,
+		StallChecks:      stallChecks,
+		MinBlockRate:     minBlockRate,
+		RejectValidators: rejectValidators,
+		ForkCheck:        forkCheck,
+		ReferenceHeight:  referenceHeight,
+		ReferenceQuorum:  referenceQuorum,
+		MaxBlocksAhead:   maxBlocksAhead,
+		Concurrency:      checkConcurrency,
+		CheckTimeout:     time.Duration(checkTimeout) * time.Second,
+		Rise:             riseChecks,
+		Fall:             fallChecks,
+		MinHealthy:       minHealthy,
+		MinHealthyMode:   minHealthyMode,
+
+		Metrics: metrics.New(registry),
+		Recorder: broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{
+			Component: "endpoint-controller",
+		}),
+	}
+
+	if leaderElect {
+		c.LeaderElection = leaderElectionConfig()
 	}
 
+	// stop the controller on pod termination
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
 	// start the controller
-	c.Run()
+	c.Run(ctx)
+	klog.Info("Endpoint controller stopped")
+}
+
+// leaderElectionConfig reads the Lease configuration from the environment.
+func leaderElectionConfig() *controller.LeaderElectionConfig {
+	// POD_NAME and POD_NAMESPACE are set through the downward API
+	identity := os.Getenv("POD_NAME")
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			klog.Fatal(err)
+		}
+		identity = hostname
+	}
+
+	leaseDuration, err := utils.GetEnv("LEASE_DURATION", defaultLeaseDuration)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	renewDeadline, err := utils.GetEnv("RENEW_DEADLINE", defaultRenewDeadline)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	retryPeriod, err := utils.GetEnv("RETRY_PERIOD", defaultRetryPeriod)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	return &controller.LeaderElectionConfig{
+		LeaseName: utils.GetEnvString("LEASE_NAME", defaultLeaseName),
+		LeaseNamespace: utils.GetEnvString("LEASE_NAMESPACE",
+			utils.GetEnvString("POD_NAMESPACE", defaultLeaseNamespace)),
+		Identity:      identity,
+		LeaseDuration: time.Duration(leaseDuration) * time.Second,
+		RenewDeadline: time.Duration(renewDeadline) * time.Second,
+		RetryPeriod:   time.Duration(retryPeriod) * time.Second,
+	}
 }
@@ -1,44 +1,58 @@
 package blockchain
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
-
-	"github.com/archway-network/endpoint-controller/pkg/utils"
 )
 
 const (
 	httpTimeout = 5
 )
 
-type NodeStatus struct {
-	Result struct {
-		SyncInfo struct {
-			LatestBlockHeight string `json:"latest_block_height"`
-		} `json:"sync_info"`
-	} `json:"result"`
+func getRequest(ctx context.Context, rpc RPCConfig, url string) ([]byte, error) {
+	return doRequest(ctx, rpc, http.MethodGet, url, nil)
+}
+
+func postRequest(ctx context.Context, rpc RPCConfig, url string, body []byte) ([]byte, error) {
+	return doRequest(ctx, rpc, http.MethodPost, url, body)
 }
 
-func getRequest(host string, path string) ([]byte, error) {
+func doRequest(ctx context.Context, rpc RPCConfig, method string, url string, body []byte) ([]byte, error) {
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: rpc.TLS,
+	}
+	defer transport.CloseIdleConnections()
 	cli := &http.Client{
-		Timeout: httpTimeout * time.Second,
+		Timeout:   httpTimeout * time.Second,
+		Transport: transport,
 	}
-	ctx := context.Background()
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+path, nil)
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
 	if err != nil {
 		klog.Error(err)
 		return nil, err
 	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, values := range rpc.Header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	if rpc.Host != "" {
+		req.Host = rpc.Host
+	}
 	resp, err := cli.Do(req)
 	if err != nil {
 		klog.Error(err)
@@ -46,6 +60,10 @@ func getRequest(host string, path string) ([]byte, error) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return nil, fmt.Errorf("%s %s returned %s", method, url, resp.Status)
+	}
+
 	b, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -54,83 +72,116 @@ func getRequest(host string, path string) ([]byte, error) {
 	return b, nil
 }
 
-func checkOpenPorts(host string, ports []corev1.EndpointPort) error {
-	timeout := httpTimeout * time.Second
+// TargetHealth is the health check result of a target.
+type TargetHealth struct {
+	Target      string
+	Healthy     bool
+	Reason      string
+	BlockHeight int
+	BlockLag    int
+	BlockTime   time.Time
+	// BlockRate is the blocks per second of the target over the previous checks,
+	// it is set by the controller and negative when unknown.
+	BlockRate float64
+	Ports     []PortCheck
+	// Validator is set when the target was rejected for being a validator.
+	Validator bool
+	// Rejected is set when the target must not be routed to even when too few targets
+	// are healthy, e.g. a validator, a node on another network, with another node id or on a fork.
+	Rejected bool
+}
 
-	for _, port := range ports {
-		klog.Infof("checking node %s port %d protocol %s", host, port.Port, port.Protocol)
-		if _, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(int(port.Port))), timeout); err != nil {
-			return fmt.Errorf(
-				"could not get correct answer from %s:%d, marking target unhealthy",
-				host,
-				port.Port)
+// TrustedTargets returns the targets of the results that were not rejected.
+func TrustedTargets(results []TargetHealth) []string {
+	var trusted []string
+	for _, result := range results {
+		if !result.Rejected {
+			trusted = append(trusted, result.Target)
 		}
 	}
-	return nil
+	return trusted
 }
 
-func CheckNodeBehind(healthy *[]string, blockMiss int) {
-	var highest int
-	var hostPort string
-	nodeBlockheights := make(map[string]int)
-
-	// loop every healthy target to check if the node is falling behind
-	for _, ip := range *healthy {
-		klog.Infof("checking node %s block height", ip)
-		var nodeStatus NodeStatus
-
-		// to make this function testable
-		if strings.Contains(ip, "127.0.0.1") {
-			hostPort = ip
-		} else {
-			hostPort = net.JoinHostPort(ip, "26657")
-		}
-
-		// get the status REST call and get the latest block height
-		data, err := getRequest(hostPort, "/status")
-		if err != nil {
-			klog.Error(err)
-			continue
-		}
-		err = json.Unmarshal(data, &nodeStatus)
-		if err != nil {
-			klog.Error(err)
-			continue
-		}
+// PortCheck is the result of the TCP check of a single port.
+type PortCheck struct {
+	Port    int32
+	Latency time.Duration
+	Err     error
+}
 
-		blockHeightInt, err := strconv.Atoi(nodeStatus.Result.SyncInfo.LatestBlockHeight)
-		if err != nil {
-			klog.Error(err)
-			continue
+// HealthyTargets returns the healthy targets of the results.
+func HealthyTargets(results []TargetHealth) []string {
+	var healthy []string
+	for _, result := range results {
+		if result.Healthy {
+			healthy = append(healthy, result.Target)
 		}
+	}
+	return healthy
+}
 
-		// set new highest block height if needed
-		if blockHeightInt > highest {
-			highest = blockHeightInt
-		}
-		nodeBlockheights[ip] = blockHeightInt
+// checkOpenPorts dials the ports of the host at the same time,
+// the error reports the first port of the list that did not accept the connection.
+func checkOpenPorts(ctx context.Context, host string, ports []corev1.EndpointPort) ([]PortCheck, error) {
+	dialer := net.Dialer{Timeout: httpTimeout * time.Second}
+	checks := make([]PortCheck, len(ports))
+
+	var wg sync.WaitGroup
+	for i, port := range ports {
+		wg.Add(1)
+		go func(i int, port corev1.EndpointPort) {
+			defer wg.Done()
+			klog.Infof("checking node %s port %d protocol %s", host, port.Port, port.Protocol)
+			start := time.Now()
+			conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(int(port.Port))))
+			checks[i] = PortCheck{Port: port.Port, Latency: time.Since(start), Err: err}
+			if err == nil {
+				_ = conn.Close()
+			}
+		}(i, port)
 	}
+	wg.Wait()
 
-	// compare block heights
-	// remove target from healthy if highest is greater than blockmiss amount
-	// loop this until there are no more unhealthy endpoints
-	for k, v := range nodeBlockheights {
-		if (highest - v) > blockMiss {
-			*healthy = utils.RemoveFromSlice(*healthy, k)
+	for _, check := range checks {
+		if check.Err != nil {
+			return checks, fmt.Errorf(
+				"could not get correct answer from %s:%d, marking target unhealthy",
+				host,
+				check.Port)
 		}
 	}
+	return checks, nil
 }
 
-func HealthCheck(ips []string, ports []corev1.EndpointPort, blockMiss int) []string {
-	var healthy []string
-	for _, ip := range ips {
-		klog.Infof("checking blockchain node (%s) health", ip)
-		if err := checkOpenPorts(ip, ports); err != nil {
-			klog.Error(err)
-			continue
+// HealthCheck checks every target with the checker concurrently, evicts the targets on a fork
+// and compares the block heights of the healthy targets to the reference height,
+// the checks, block hashes and reference heights share the timeout of the config,
+// only the checks take from its limiter.
+func HealthCheck(ctx context.Context, checker Checker, ips []string, config Config) []TargetHealth {
+	ctx, cancel := withTimeout(ctx, config)
+	// the calls still running are aborted once the results are returned
+	defer cancel()
+
+	// the reference RPCs are read while the targets are checked
+	external := make(chan int, 1)
+	go func() {
+		var height int
+		if referencer, ok := checker.(ReferenceHeighter); ok {
+			height = externalHeight(ctx, referencer, config)
 		}
-		healthy = append(healthy, ip)
+		external <- height
+	}()
+
+	results := checkConcurrently(ctx, ips, config, func(ctx context.Context, ip string) TargetHealth {
+		klog.Infof("checking blockchain node (%s) health", ip)
+		return checker.Check(ctx, ip, config)
+	})
+
+	// forked targets must not become the reference height
+	if hasher, ok := checker.(BlockHasher); ok && config.ForkCheck {
+		checkForks(ctx, hasher, results, config)
 	}
-	CheckNodeBehind(&healthy, blockMiss)
-	return healthy
+
+	compareBlockHeights(results, config, <-external)
+	return results
 }
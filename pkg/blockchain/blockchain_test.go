@@ -1,9 +1,13 @@
 package blockchain_test
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -32,31 +36,145 @@ func handleGetRequest3(w http.ResponseWriter, _ *http.Request) {
 	_ = json.NewEncoder(w).Encode(response)
 }
 
-func createTestServer(handler http.Handler) *httptest.Server {
-	return httptest.NewServer(handler)
+// newLoopbackServer starts a test server on the given loopback ip and port,
+// so several targets can share the same probe port.
+func newLoopbackServer(t *testing.T, ip string, port string, handler http.Handler) *httptest.Server {
+	listener, err := net.Listen("tcp", net.JoinHostPort(ip, port))
+	if err != nil {
+		t.Skipf("could not listen on %s: %v", ip, err)
+	}
+
+	ts := httptest.NewUnstartedServer(handler)
+	ts.Listener.Close()
+	ts.Listener = listener
+	ts.Start()
+	return ts
+}
+
+// serverPort returns the port of the test server.
+func serverPort(ts *httptest.Server) string {
+	return strconv.Itoa(ts.Listener.Addr().(*net.TCPAddr).Port)
 }
 
 func TestHandleGetRequests(t *testing.T) {
 	// Create the first test server
-	ts1 := createTestServer(http.HandlerFunc(handleGetRequest1))
+	ts1 := newLoopbackServer(t, "127.0.0.1", "0", http.HandlerFunc(handleGetRequest1))
 	defer ts1.Close()
+	port := serverPort(ts1)
 
 	// Create the second test server
-	ts2 := createTestServer(http.HandlerFunc(handleGetRequest2))
+	ts2 := newLoopbackServer(t, "127.0.0.2", port, http.HandlerFunc(handleGetRequest2))
 	defer ts2.Close()
 
 	// Create the third test server
-	ts3 := createTestServer(http.HandlerFunc(handleGetRequest3))
+	ts3 := newLoopbackServer(t, "127.0.0.3", port, http.HandlerFunc(handleGetRequest3))
 	defer ts3.Close()
 
-	healthy := []string{
-		ts1.Listener.Addr().String(),
-		ts2.Listener.Addr().String(),
-		ts3.Listener.Addr().String(),
+	ips := []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"}
+	expectedHealthy := []string{"127.0.0.1", "127.0.0.2"}
+
+	results := blockchain.HealthCheck(context.Background(), blockchain.CosmosChecker{}, ips, blockchain.Config{
+		BlockMiss: 6,
+		RPC:       blockchain.RPCConfig{Port: port},
+	})
+
+	assert.Equal(t, expectedHealthy, blockchain.HealthyTargets(results))
+}
+
+func TestRPCConfigURL(t *testing.T) {
+	assert.Equal(t, "http://1.1.1.1:26657/status",
+		blockchain.RPCConfig{}.URL("1.1.1.1", "26657", "/status"))
+	assert.Equal(t, "https://1.1.1.1:443/rpc/status",
+		blockchain.RPCConfig{Scheme: "https", Port: "443", Path: "rpc/status"}.URL("1.1.1.1", "26657", "/status"))
+	assert.Equal(t, "http://[2001:db8::1]:26657/status",
+		blockchain.RPCConfig{}.URL("2001:db8::1", "26657", "/status"))
+}
+
+// staticChecker reports fixed block heights and treats unknown targets as down.
+type staticChecker map[string]int
+
+func (s staticChecker) Check(_ context.Context, target string, _ blockchain.Config) blockchain.TargetHealth {
+	height, ok := s[target]
+	if !ok {
+		return blockchain.TargetHealth{Target: target, Reason: "target is down"}
 	}
-	expectedHealthy := []string{ts1.Listener.Addr().String(), ts2.Listener.Addr().String()}
+	return blockchain.TargetHealth{Target: target, Healthy: true, BlockHeight: height}
+}
+
+func TestHealthCheck(t *testing.T) {
+	checker := staticChecker{"1.1.1.1": 1000, "2.2.2.2": 1002, "3.3.3.3": 992}
+	ips := []string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "4.4.4.4"}
+
+	results := blockchain.HealthCheck(context.Background(), checker, ips, blockchain.Config{BlockMiss: 6})
+
+	assert.Equal(t, []string{"1.1.1.1", "2.2.2.2"}, blockchain.HealthyTargets(results))
+	assert.Equal(t, 2, results[0].BlockLag)
+	assert.Equal(t, 10, results[2].BlockLag)
+	assert.Equal(t, "block height 992 is 10 blocks behind 1002", results[2].Reason)
+	assert.Equal(t, "target is down", results[3].Reason)
+}
+
+func TestRegistry(t *testing.T) {
+	registry := blockchain.NewRegistry()
+
+	checker, ok := registry.Get(blockchain.DefaultChecker)
+	assert.True(t, ok)
+	assert.IsType(t, blockchain.CosmosChecker{}, checker)
+
+	_, ok = registry.Get("static")
+	assert.False(t, ok)
+
+	registry.Register("static", staticChecker{})
+	_, ok = registry.Get("static")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"cosmos", "evm", "static"}, registry.Names())
+}
+
+// newJSONRPCServer starts a JSON-RPC stand-in on the loopback ip answering
+// eth_blockNumber and eth_syncing with the given results.
+func newJSONRPCServer(t *testing.T, ip string, port string, blockNumber string, syncing string) *httptest.Server {
+	return newLoopbackServer(t, ip, port, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Method string `json:"method"`
+			ID     int    `json:"id"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&request)
+
+		result := blockNumber
+		if request.Method == "eth_syncing" {
+			result = syncing
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":%s}`, request.ID, result)
+	}))
+}
+
+func TestEVMChecker(t *testing.T) {
+	// listen on the same port of several loopback ips
+	ts1 := newJSONRPCServer(t, "127.0.0.1", "0", `"0x3e8"`, "false")
+	defer ts1.Close()
+	port := serverPort(ts1)
+
+	ts2 := newJSONRPCServer(t, "127.0.0.2", port, `"0x3ea"`, "false")
+	defer ts2.Close()
+
+	ts3 := newJSONRPCServer(t, "127.0.0.3", port, `"0x3e0"`, "false")
+	defer ts3.Close()
+
+	ts4 := newJSONRPCServer(t, "127.0.0.4", port, `"0x3ea"`,
+		`{"startingBlock":"0x0","currentBlock":"0x3ea","highestBlock":"0x400"}`)
+	defer ts4.Close()
+
+	ips := []string{"127.0.0.1", "127.0.0.2", "127.0.0.3", "127.0.0.4"}
 
-	blockchain.CheckNodeBehind(&healthy, 6)
+	results := blockchain.HealthCheck(context.Background(), blockchain.EVMChecker{}, ips, blockchain.Config{
+		BlockMiss: 6,
+		RPC:       blockchain.RPCConfig{Port: port},
+	})
 
-	assert.Equal(t, expectedHealthy, healthy)
+	assert.Equal(t, []string{"127.0.0.1", "127.0.0.2"}, blockchain.HealthyTargets(results))
+	assert.Equal(t, 1000, results[0].BlockHeight)
+	assert.Equal(t, 1002, results[1].BlockHeight)
+	assert.Equal(t, "block height 992 is 10 blocks behind 1002", results[2].Reason)
+	assert.Equal(t, "node is syncing, current block 0x3ea highest block 0x400", results[3].Reason)
 }
@@ -0,0 +1,159 @@
+package blockchain
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DefaultChecker is the checker used when a service does not select one.
+const DefaultChecker = "cosmos"
+
+// version sources select the versions checked against the version constraint.
+const (
+	// VersionSourceApp checks application_version.version of the Cosmos REST node_info.
+	VersionSourceApp = "app"
+	// VersionSourceCometBFT checks node_info.version of the CometBFT /status.
+	VersionSourceCometBFT = "cometbft"
+	// VersionSourceBoth checks both versions.
+	VersionSourceBoth = "both"
+)
+
+// Config holds the health check settings of a service.
+type Config struct {
+	Ports     []corev1.EndpointPort
+	BlockMiss int
+	RPC       RPCConfig
+	// MaxBlockAge marks targets whose latest block is older unhealthy, 0 disables the check.
+	MaxBlockAge time.Duration
+	// ChainID marks targets on another network unhealthy, empty disables the check.
+	ChainID string
+	// NodeIDs holds the expected node ID of targets by IP, targets reporting another
+	// node ID are unhealthy.
+	NodeIDs map[string]string
+	// Version marks targets whose version does not satisfy the constraint unhealthy,
+	// nil disables the check. VersionSource selects the checked versions.
+	Version       *semver.Constraints
+	VersionSource string
+	// APIPort is the port of the Cosmos REST API, empty uses the default port.
+	APIPort string
+	// MinPeers marks targets with less peers unhealthy, 0 disables the check.
+	MinPeers int
+	// MaxEarliestHeight marks targets whose earliest block is above unhealthy,
+	// 1 only allows archive nodes, 0 disables the check.
+	MaxEarliestHeight int
+	// RejectValidators marks targets with voting power unhealthy.
+	RejectValidators bool
+	// ForkCheck compares the block hashes of the targets when the checker is a BlockHasher.
+	ForkCheck bool
+	// ReferenceStrategy selects how the reference height of the block miss rule is
+	// computed, empty uses ReferenceMax. ReferenceQuorum is the number of targets of
+	// the quorum strategy.
+	ReferenceStrategy string
+	ReferenceQuorum   int
+	// MaxBlocksAhead marks targets further ahead of the reference height unhealthy,
+	// 0 uses BlockMiss.
+	MaxBlocksAhead int
+	// ReferenceURLs are trusted RPC URLs whose highest block height is used as reference
+	// when the checker is a ReferenceHeighter, ExternalReference selects how it is
+	// combined with the pool, empty uses ExternalReferenceCombined.
+	ReferenceURLs     []string
+	ExternalReference string
+	// Limiter bounds the targets checked at the same time, nil does not limit them.
+	Limiter *Limiter
+	// Timeout is the deadline of the target checks, targets still checked are unhealthy,
+	// 0 waits for every check.
+	Timeout time.Duration
+}
+
+// RPCConfig defines where and how the block height probe is sent,
+// empty fields use the defaults of the checker.
+type RPCConfig struct {
+	Scheme string
+	Port   string
+	Path   string
+
+	// TLS is used for https probes, nil verifies the node with the system roots.
+	TLS *tls.Config
+	// Host overrides the Host header of the probes.
+	Host string
+	// Header is added to every probe, e.g. an Authorization header.
+	Header http.Header
+}
+
+// URL returns the probe URL of the target.
+func (r RPCConfig) URL(target string, defaultPort string, defaultPath string) string {
+	scheme := r.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	port := r.Port
+	if port == "" {
+		port = defaultPort
+	}
+	path := r.Path
+	if path == "" {
+		path = defaultPath
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return scheme + "://" + net.JoinHostPort(target, port) + path
+}
+
+// Checker checks the health of a single target of a chain type.
+// The returned TargetHealth holds the block height when the target reports one,
+// block heights are compared between the targets of a service by HealthCheck.
+type Checker interface {
+	Check(ctx context.Context, target string, config Config) TargetHealth
+}
+
+// Registry holds the checkers by chain type.
+type Registry struct {
+	mutex    sync.RWMutex
+	checkers map[string]Checker
+}
+
+// NewRegistry returns a registry with the built in checkers registered.
+func NewRegistry() *Registry {
+	r := &Registry{checkers: map[string]Checker{}}
+	r.Register(DefaultChecker, CosmosChecker{})
+	r.Register("evm", EVMChecker{})
+	return r
+}
+
+// Register adds a checker for the chain type, replacing any existing one.
+func (r *Registry) Register(name string, checker Checker) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.checkers[name] = checker
+}
+
+// Get returns the checker of the chain type.
+func (r *Registry) Get(name string) (Checker, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	checker, ok := r.checkers[name]
+	return checker, ok
+}
+
+// Names returns the sorted chain types of the registered checkers.
+func (r *Registry) Names() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	names := make([]string, 0, len(r.checkers))
+	for name := range r.checkers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,122 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"k8s.io/klog/v2"
+)
+
+// Limiter bounds the number of targets checked at the same time,
+// it is shared by the services so a large service cannot exhaust the controller.
+// A nil Limiter does not limit the checks.
+type Limiter struct {
+	slots chan struct{}
+}
+
+// NewLimiter returns a Limiter allowing size checks at the same time, nil when size is not positive.
+func NewLimiter(size int) *Limiter {
+	if size <= 0 {
+		return nil
+	}
+	return &Limiter{slots: make(chan struct{}, size)}
+}
+
+// acquire waits for a free slot, it returns false when stop is closed first.
+func (l *Limiter) acquire(stop <-chan struct{}) bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// release frees the slot taken by acquire.
+func (l *Limiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+// withTimeout returns a context canceled after the timeout of the config, 0 does not set a deadline.
+func withTimeout(ctx context.Context, config Config) (context.Context, context.CancelFunc) {
+	if config.Timeout > 0 {
+		return context.WithTimeout(ctx, config.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// indexed is the result of the call on the item at index i.
+type indexed[R any] struct {
+	i      int
+	result R
+}
+
+// concurrently
+// runs call on every item in its own goroutine within the limits of the limiter
+// returns the results in the order of the items once every call returned or ctx is done
+// the booleans report the calls that returned in time, the others are left running.
+func concurrently[T, R any](
+	ctx context.Context,
+	items []T,
+	limiter *Limiter,
+	call func(ctx context.Context, item T) R,
+) ([]R, []bool) {
+	// the channel is buffered so calls returning after ctx is done do not block
+	done := make(chan indexed[R], len(items))
+	for i, item := range items {
+		go func(i int, item T) {
+			if !limiter.acquire(ctx.Done()) {
+				return
+			}
+			defer limiter.release()
+			done <- indexed[R]{i: i, result: call(ctx, item)}
+		}(i, item)
+	}
+
+	results := make([]R, len(items))
+	finished := make([]bool, len(items))
+	for received := 0; received < len(items); received++ {
+		select {
+		case r := <-done:
+			results[r.i] = r.result
+			finished[r.i] = true
+		case <-ctx.Done():
+			return results, finished
+		}
+	}
+	return results, finished
+}
+
+// checkConcurrently
+// runs the check of every target in its own goroutine within the limits of the limiter
+// targets whose check did not finish before the deadline of ctx or was canceled are unhealthy.
+func checkConcurrently(
+	ctx context.Context,
+	ips []string,
+	config Config,
+	check func(ctx context.Context, ip string) TargetHealth,
+) []TargetHealth {
+	results, finished := concurrently(ctx, ips, config.Limiter, check)
+
+	var reason string
+	for i, ip := range ips {
+		if finished[i] {
+			continue
+		}
+		if reason == "" {
+			reason = "health check was canceled"
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				reason = fmt.Sprintf("health check did not finish within %s", config.Timeout)
+			}
+		}
+		klog.Errorf("target %s %s", ip, reason)
+		results[i] = TargetHealth{Target: ip, Reason: reason}
+	}
+	return results
+}
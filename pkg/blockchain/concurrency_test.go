@@ -0,0 +1,140 @@
+package blockchain_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/archway-network/endpoint-controller/pkg/blockchain"
+)
+
+// blockingChecker blocks the checks of the slow target until release is closed
+// and records the number of checks running at the same time.
+type blockingChecker struct {
+	slow    string
+	release chan struct{}
+	delay   time.Duration
+
+	mutex   sync.Mutex
+	running int
+	peak    int
+}
+
+func (b *blockingChecker) Check(_ context.Context, target string, _ blockchain.Config) blockchain.TargetHealth {
+	b.mutex.Lock()
+	b.running++
+	if b.running > b.peak {
+		b.peak = b.running
+	}
+	b.mutex.Unlock()
+
+	if target == b.slow {
+		<-b.release
+	}
+	time.Sleep(b.delay)
+
+	b.mutex.Lock()
+	b.running--
+	b.mutex.Unlock()
+	return blockchain.TargetHealth{Target: target, Healthy: true, BlockHeight: 1000}
+}
+
+func TestSlowTargetDoesNotStallPool(t *testing.T) {
+	checker := &blockingChecker{slow: "1.1.1.1", release: make(chan struct{})}
+	defer close(checker.release)
+	ips := []string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "4.4.4.4", "5.5.5.5"}
+
+	start := time.Now()
+	results := blockchain.HealthCheck(context.Background(), checker, ips, blockchain.Config{
+		BlockMiss: 6,
+		Limiter:   blockchain.NewLimiter(2),
+		Timeout:   200 * time.Millisecond,
+	})
+
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, []string{"2.2.2.2", "3.3.3.3", "4.4.4.4", "5.5.5.5"}, blockchain.HealthyTargets(results))
+	assert.Equal(t, "1.1.1.1", results[0].Target)
+	assert.Equal(t, "health check did not finish within 200ms", results[0].Reason)
+}
+
+func TestLimiter(t *testing.T) {
+	checker := &blockingChecker{release: make(chan struct{}), delay: 100 * time.Millisecond}
+	ips := []string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "4.4.4.4", "5.5.5.5", "6.6.6.6"}
+
+	results := blockchain.HealthCheck(context.Background(), checker, ips, blockchain.Config{
+		BlockMiss: 6,
+		Limiter:   blockchain.NewLimiter(2),
+	})
+
+	assert.Equal(t, ips, blockchain.HealthyTargets(results))
+	assert.Equal(t, 2, checker.peak)
+
+	// a nil limiter checks every target at the same time
+	checker.peak = 0
+	blockchain.HealthCheck(context.Background(), checker, ips, blockchain.Config{
+		BlockMiss: 6,
+		Limiter:   blockchain.NewLimiter(0),
+	})
+	assert.Equal(t, len(ips), checker.peak)
+}
+
+// hangingChecker reports fixed block heights, block hashes and reference heights,
+// the block hashes of the slow target and the slow reference URL hang until the context is done.
+type hangingChecker struct {
+	staticChecker
+	hashes     map[string]string
+	references map[string]int
+	slow       string
+}
+
+func (h hangingChecker) BlockHashes(
+	ctx context.Context,
+	target string,
+	_ int,
+	_ blockchain.Config,
+) (blockchain.BlockHashes, error) {
+	if target == h.slow {
+		<-ctx.Done()
+		return blockchain.BlockHashes{}, ctx.Err()
+	}
+	return blockchain.BlockHashes{BlockHash: h.hashes[target], AppHash: h.hashes[target]}, nil
+}
+
+func (h hangingChecker) ReferenceHeight(ctx context.Context, url string, _ blockchain.Config) (int, error) {
+	if url == h.slow {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+	return h.references[url], nil
+}
+
+func TestSlowHashesAndReferencesShareDeadline(t *testing.T) {
+	checker := hangingChecker{
+		staticChecker: staticChecker{"1.1.1.1": 1000, "2.2.2.2": 1000, "3.3.3.3": 1000, "4.4.4.4": 1000},
+		hashes:        map[string]string{"1.1.1.1": "AAAA", "2.2.2.2": "AAAA", "3.3.3.3": "BBBB"},
+		references:    map[string]int{"https://rpc.example.com": 1010},
+		slow:          "4.4.4.4",
+	}
+	ips := []string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "4.4.4.4"}
+
+	// the limiter is smaller than the hanging and answering calls together
+	start := time.Now()
+	results := blockchain.HealthCheck(context.Background(), checker, ips, blockchain.Config{
+		BlockMiss:     6,
+		ForkCheck:     true,
+		ReferenceURLs: []string{"https://rpc.example.com", "4.4.4.4"},
+		Limiter:       blockchain.NewLimiter(2),
+		Timeout:       200 * time.Millisecond,
+	})
+
+	// the hanging calls are abandoned at the deadline of the service
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Contains(t, results[2].Reason, "diverges from the majority")
+
+	// the answering reference is used, so every target is behind
+	assert.Empty(t, blockchain.HealthyTargets(results))
+	assert.Equal(t, 10, results[0].BlockLag)
+}
@@ -0,0 +1,431 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"k8s.io/klog/v2"
+)
+
+// NodeStatus is the response of the CometBFT /status endpoint.
+type NodeStatus struct {
+	Result struct {
+		NodeInfo      NodeInfo      `json:"node_info"`
+		SyncInfo      SyncInfo      `json:"sync_info"`
+		ValidatorInfo ValidatorInfo `json:"validator_info"`
+	} `json:"result"`
+}
+
+// NodeInfo describes the node and the network it is connected to.
+type NodeInfo struct {
+	ProtocolVersion struct {
+		P2P   string `json:"p2p"`
+		Block string `json:"block"`
+		App   string `json:"app"`
+	} `json:"protocol_version"`
+	ID         string `json:"id"`
+	ListenAddr string `json:"listen_addr"`
+	Network    string `json:"network"`
+	Version    string `json:"version"`
+	Channels   string `json:"channels"`
+	Moniker    string `json:"moniker"`
+	Other      struct {
+		TxIndex    string `json:"tx_index"`
+		RPCAddress string `json:"rpc_address"`
+	} `json:"other"`
+}
+
+// SyncInfo describes the blocks stored by the node.
+type SyncInfo struct {
+	LatestBlockHash     string    `json:"latest_block_hash"`
+	LatestAppHash       string    `json:"latest_app_hash"`
+	LatestBlockHeight   string    `json:"latest_block_height"`
+	LatestBlockTime     time.Time `json:"latest_block_time"`
+	EarliestBlockHash   string    `json:"earliest_block_hash"`
+	EarliestAppHash     string    `json:"earliest_app_hash"`
+	EarliestBlockHeight string    `json:"earliest_block_height"`
+	EarliestBlockTime   time.Time `json:"earliest_block_time"`
+	CatchingUp          bool      `json:"catching_up"`
+}
+
+// NetInfo is the response of the CometBFT /net_info endpoint.
+type NetInfo struct {
+	Result struct {
+		Listening bool     `json:"listening"`
+		Listeners []string `json:"listeners"`
+		NPeers    string   `json:"n_peers"`
+	} `json:"result"`
+}
+
+// ValidatorInfo describes the validator key of the node.
+type ValidatorInfo struct {
+	Address string `json:"address"`
+	PubKey  struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	} `json:"pub_key"`
+	VotingPower string `json:"voting_power"`
+}
+
+// Block is the response of the CometBFT /block endpoint.
+type Block struct {
+	Result struct {
+		BlockID struct {
+			Hash string `json:"hash"`
+		} `json:"block_id"`
+		Block struct {
+			Header struct {
+				ChainID string `json:"chain_id"`
+				Height  string `json:"height"`
+				AppHash string `json:"app_hash"`
+			} `json:"header"`
+		} `json:"block"`
+	} `json:"result"`
+}
+
+// CosmosNodeInfo is the response of the Cosmos REST node_info endpoint.
+type CosmosNodeInfo struct {
+	ApplicationVersion struct {
+		Name             string `json:"name"`
+		AppName          string `json:"app_name"`
+		Version          string `json:"version"`
+		GitCommit        string `json:"git_commit"`
+		GoVersion        string `json:"go_version"`
+		CosmosSDKVersion string `json:"cosmos_sdk_version"`
+	} `json:"application_version"`
+}
+
+const (
+	defaultCosmosRPCPort      = "26657"
+	defaultCosmosStatusPath   = "/status"
+	defaultCosmosAPIPort      = "1317"
+	defaultCosmosNodeInfoPath = "/cosmos/base/tendermint/v1beta1/node_info"
+)
+
+// CosmosChecker checks CometBFT based nodes, every port has to accept
+// TCP connections and the block height is read from the RPC /status endpoint.
+type CosmosChecker struct{}
+
+// Check checks a single Cosmos node.
+func (CosmosChecker) Check(ctx context.Context, target string, config Config) TargetHealth {
+	result := TargetHealth{Target: target, Healthy: true}
+
+	checks, err := checkOpenPorts(ctx, target, config.Ports)
+	result.Ports = checks
+	if err != nil {
+		klog.Error(err)
+		result.Healthy = false
+		result.Reason = err.Error()
+		return result
+	}
+
+	checkCometBFTStatus(ctx, &result, config)
+
+	return result
+}
+
+// checkCometBFTStatus
+// reads the block height of the target from the CometBFT /status endpoint
+// nodes on another network than the chain id are unhealthy
+// nodes with another node id than the expected one are unhealthy
+// nodes with voting power are unhealthy when validators are rejected
+// nodes whose version does not satisfy the version constraint are unhealthy
+// nodes with less peers than the minimum are unhealthy
+// nodes that pruned blocks above the max earliest height are unhealthy
+// nodes catching up are unhealthy regardless of their block height
+// nodes whose latest block is older than the max block age are unhealthy
+// nodes that do not answer on /status are unhealthy when a chain id is set, their node id
+// is pinned or validators are rejected and not compared otherwise.
+func checkCometBFTStatus(ctx context.Context, result *TargetHealth, config Config) {
+	status, err := cometBFTStatus(ctx, config.RPC,
+		config.RPC.URL(result.Target, defaultCosmosRPCPort, defaultCosmosStatusPath))
+	if err != nil {
+		klog.Error(err)
+		nodeID, pinned := config.NodeIDs[result.Target]
+		switch {
+		case config.ChainID != "":
+			// a node that cannot prove its network may be on another one
+			result.Healthy = false
+			result.Rejected = true
+			result.Reason = fmt.Sprintf("could not verify the chain id %q: %s", config.ChainID, err)
+		case pinned:
+			// the target IP may have been reassigned to a machine without RPC
+			result.Healthy = false
+			result.Rejected = true
+			result.Reason = fmt.Sprintf("could not verify the node id %q: %s", nodeID, err)
+		case config.RejectValidators:
+			// a validator with a firewalled RPC must not receive public traffic either
+			result.Healthy = false
+			result.Rejected = true
+			result.Reason = fmt.Sprintf("could not verify that the node is not a validator: %s", err)
+		}
+		return
+	}
+
+	// heights of another network are not reported
+	if config.ChainID != "" && status.Result.NodeInfo.Network != config.ChainID {
+		result.Healthy = false
+		result.Rejected = true
+		result.Reason = fmt.Sprintf("node is on network %q, expected chain id %q",
+			status.Result.NodeInfo.Network, config.ChainID)
+		return
+	}
+
+	// the target IP may have been reassigned to another machine
+	if nodeID, ok := config.NodeIDs[result.Target]; ok && !strings.EqualFold(status.Result.NodeInfo.ID, nodeID) {
+		klog.Errorf("target %s reports node id %q, expected node id %q",
+			result.Target, status.Result.NodeInfo.ID, nodeID)
+		result.Healthy = false
+		result.Rejected = true
+		result.Reason = fmt.Sprintf("node id %q does not match the expected node id %q",
+			status.Result.NodeInfo.ID, nodeID)
+		return
+	}
+
+	// validators must not receive public traffic
+	if config.RejectValidators {
+		if reason := checkValidator(status.Result.ValidatorInfo); reason != "" {
+			klog.Errorf("refusing to route to target %s: %s", result.Target, reason)
+			result.Healthy = false
+			result.Validator = true
+			result.Rejected = true
+			result.Reason = reason
+			return
+		}
+	}
+
+	if config.Version != nil {
+		if reason := checkNodeVersion(ctx, result.Target, status, config); reason != "" {
+			result.Healthy = false
+			result.Reason = reason
+			return
+		}
+	}
+
+	height, err := strconv.Atoi(status.Result.SyncInfo.LatestBlockHeight)
+	if err != nil {
+		klog.Error(err)
+	}
+	result.BlockHeight = height
+	result.BlockTime = status.Result.SyncInfo.LatestBlockTime
+
+	if status.Result.SyncInfo.CatchingUp {
+		result.Healthy = false
+		result.Reason = fmt.Sprintf("node is catching up at block height %d", height)
+		return
+	}
+
+	if config.MaxEarliestHeight > 0 {
+		checkEarliestHeight(result, status.Result.SyncInfo.EarliestBlockHeight, config.MaxEarliestHeight)
+	}
+
+	checkBlockAge(result, config.MaxBlockAge)
+
+	if result.Healthy && config.MinPeers > 0 {
+		checkPeers(ctx, result, config)
+	}
+}
+
+// checkPeers marks the target unhealthy when it has less peers than the minimum,
+// nodes with few peers are about to fall behind.
+func checkPeers(ctx context.Context, result *TargetHealth, config Config) {
+	netInfo, err := cometBFTNetInfo(ctx, config.RPC, cometBFTURL(config.RPC, result.Target, "net_info"))
+	if err != nil {
+		klog.Error(err)
+		result.Healthy = false
+		result.Reason = fmt.Sprintf("could not get the peer count: %s", err)
+		return
+	}
+
+	peers, err := strconv.Atoi(netInfo.Result.NPeers)
+	if err != nil {
+		result.Healthy = false
+		result.Reason = fmt.Sprintf("invalid peer count %q", netInfo.Result.NPeers)
+		return
+	}
+	if peers < config.MinPeers {
+		result.Healthy = false
+		result.Reason = fmt.Sprintf("node has %d peers, minimum is %d", peers, config.MinPeers)
+	}
+}
+
+// BlockHashes reads the hashes of the block at the height from the CometBFT /block endpoint.
+func (CosmosChecker) BlockHashes(ctx context.Context, target string, height int, config Config) (BlockHashes, error) {
+	url := cometBFTURL(config.RPC, target, "block") + "?height=" + strconv.Itoa(height)
+	klog.Infof("checking block hashes on %s", url)
+
+	data, err := getRequest(ctx, config.RPC, url)
+	if err != nil {
+		return BlockHashes{}, err
+	}
+
+	var block Block
+	if err = json.Unmarshal(data, &block); err != nil {
+		return BlockHashes{}, err
+	}
+	if block.Result.BlockID.Hash == "" {
+		return BlockHashes{}, fmt.Errorf("%s returned no block hash", url)
+	}
+	return BlockHashes{
+		BlockHash: block.Result.BlockID.Hash,
+		AppHash:   block.Result.Block.Header.AppHash,
+	}, nil
+}
+
+// ReferenceHeight reads the latest block height of a trusted CometBFT /status URL,
+// the probe settings of the targets are not sent to it.
+func (CosmosChecker) ReferenceHeight(ctx context.Context, url string, config Config) (int, error) {
+	status, err := cometBFTStatus(ctx, RPCConfig{}, url)
+	if err != nil {
+		return 0, err
+	}
+	if config.ChainID != "" && status.Result.NodeInfo.Network != config.ChainID {
+		return 0, fmt.Errorf("%s is on network %q, expected chain id %q",
+			url, status.Result.NodeInfo.Network, config.ChainID)
+	}
+	return strconv.Atoi(status.Result.SyncInfo.LatestBlockHeight)
+}
+
+// cometBFTURL returns the URL of a CometBFT RPC endpoint,
+// endpoints are siblings of the /status path.
+func cometBFTURL(rpc RPCConfig, target string, endpoint string) string {
+	statusPath := rpc.Path
+	if statusPath == "" {
+		statusPath = defaultCosmosStatusPath
+	}
+	rpc.Path = path.Join(path.Dir(statusPath), endpoint)
+	return rpc.URL(target, defaultCosmosRPCPort, defaultCosmosStatusPath)
+}
+
+// cometBFTNetInfo gets the peers of the node from the CometBFT /net_info endpoint.
+func cometBFTNetInfo(ctx context.Context, rpc RPCConfig, url string) (NetInfo, error) {
+	var netInfo NetInfo
+	klog.Infof("checking node peers on %s", url)
+
+	data, err := getRequest(ctx, rpc, url)
+	if err != nil {
+		return netInfo, err
+	}
+	err = json.Unmarshal(data, &netInfo)
+	return netInfo, err
+}
+
+// checkValidator returns the reason when the node has voting power or it cannot be read.
+func checkValidator(validator ValidatorInfo) string {
+	power, err := strconv.ParseInt(validator.VotingPower, 10, 64)
+	if err != nil {
+		return fmt.Sprintf("node may be a validator, invalid voting power %q", validator.VotingPower)
+	}
+	if power > 0 {
+		return fmt.Sprintf("node is a validator with voting power %d", power)
+	}
+	return ""
+}
+
+// checkNodeVersion
+// checks the versions selected by the version source against the version constraint
+// return the reason when a version does not satisfy it or cannot be read.
+func checkNodeVersion(ctx context.Context, target string, status NodeStatus, config Config) string {
+	source := config.VersionSource
+	if source == "" {
+		source = VersionSourceApp
+	}
+
+	if source == VersionSourceCometBFT || source == VersionSourceBoth {
+		if reason := checkVersion("CometBFT", status.Result.NodeInfo.Version, config.Version); reason != "" {
+			return reason
+		}
+	}
+
+	if source == VersionSourceApp || source == VersionSourceBoth {
+		api := config.RPC
+		api.Port = config.APIPort
+		api.Path = ""
+		nodeInfo, err := cosmosNodeInfo(ctx, api, api.URL(target, defaultCosmosAPIPort, defaultCosmosNodeInfoPath))
+		if err != nil {
+			klog.Error(err)
+			return fmt.Sprintf("could not get the application version: %s", err)
+		}
+		if reason := checkVersion("application", nodeInfo.ApplicationVersion.Version, config.Version); reason != "" {
+			return reason
+		}
+	}
+
+	return ""
+}
+
+// checkVersion returns the reason when the version does not satisfy the constraint.
+func checkVersion(name string, version string, constraint *semver.Constraints) string {
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return fmt.Sprintf("%s version %q is not a semantic version", name, version)
+	}
+	if !constraint.Check(v) {
+		return fmt.Sprintf("%s version %s does not satisfy %s", name, version, constraint)
+	}
+	return ""
+}
+
+// cosmosNodeInfo gets the application version from the Cosmos REST node_info endpoint.
+func cosmosNodeInfo(ctx context.Context, rpc RPCConfig, url string) (CosmosNodeInfo, error) {
+	var nodeInfo CosmosNodeInfo
+	klog.Infof("checking node version on %s", url)
+
+	data, err := getRequest(ctx, rpc, url)
+	if err != nil {
+		return nodeInfo, err
+	}
+	err = json.Unmarshal(data, &nodeInfo)
+	return nodeInfo, err
+}
+
+// cometBFTStatus gets the node status from the CometBFT /status endpoint.
+func cometBFTStatus(ctx context.Context, rpc RPCConfig, url string) (NodeStatus, error) {
+	var nodeStatus NodeStatus
+	klog.Infof("checking node status on %s", url)
+
+	// get the status REST call
+	data, err := getRequest(ctx, rpc, url)
+	if err != nil {
+		return nodeStatus, err
+	}
+	err = json.Unmarshal(data, &nodeStatus)
+	return nodeStatus, err
+}
+
+// checkEarliestHeight marks the target unhealthy when it pruned blocks below maxHeight,
+// so archive services only point at archive nodes.
+func checkEarliestHeight(result *TargetHealth, earliestHeight string, maxHeight int) {
+	earliest, err := strconv.Atoi(earliestHeight)
+	if err != nil {
+		result.Healthy = false
+		result.Reason = fmt.Sprintf("invalid earliest block height %q", earliestHeight)
+		return
+	}
+	if earliest > maxHeight {
+		result.Healthy = false
+		result.Reason = fmt.Sprintf("earliest block height %d is above %d, the node is pruned",
+			earliest, maxHeight)
+	}
+}
+
+// checkBlockAge marks the target unhealthy when its latest block is older than maxAge,
+// the reason differs from falling behind so a halted chain can be told apart from a lagging node.
+func checkBlockAge(result *TargetHealth, maxAge time.Duration) {
+	if maxAge <= 0 || result.BlockTime.IsZero() {
+		return
+	}
+
+	age := time.Since(result.BlockTime)
+	if age > maxAge {
+		result.Healthy = false
+		result.Reason = fmt.Sprintf("latest block %d is %s old, older than the max block age %s",
+			result.BlockHeight, age.Round(time.Second), maxAge)
+	}
+}
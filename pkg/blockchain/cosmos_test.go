@@ -0,0 +1,355 @@
+package blockchain_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/archway-network/endpoint-controller/pkg/blockchain"
+)
+
+const statusResponse = `{
+  "jsonrpc": "2.0",
+  "id": -1,
+  "result": {
+    "node_info": {
+      "protocol_version": {"p2p": "8", "block": "11", "app": "0"},
+      "id": "5c2a752c9b1952dbed075c56c600c3a79b58c395",
+      "listen_addr": "tcp://0.0.0.0:26656",
+      "network": "archway-1",
+      "version": "0.37.2",
+      "channels": "40202122233038606100",
+      "moniker": "archway-node",
+      "other": {"tx_index": "on", "rpc_address": "tcp://0.0.0.0:26657"}
+    },
+    "sync_info": {
+      "latest_block_hash": "B6A5B5B4C58E8F0A9D1C6E5F4A3B2C1D0E9F8A7B6C5D4E3F2A1B0C9D8E7F6A5B",
+      "latest_app_hash": "0E9F8A7B6C5D4E3F2A1B0C9D8E7F6A5BB6A5B5B4C58E8F0A9D1C6E5F4A3B2C1D",
+      "latest_block_height": "1000",
+      "latest_block_time": "2023-05-04T10:00:00.123456789Z",
+      "earliest_block_hash": "C58E8F0A9D1C6E5F4A3B2C1D0E9F8A7B6C5D4E3F2A1B0C9D8E7F6A5BB6A5B5B4",
+      "earliest_app_hash": "E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855",
+      "earliest_block_height": "1",
+      "earliest_block_time": "2023-01-01T00:00:00Z",
+      "catching_up": true
+    }
+  }
+}`
+
+func TestNodeStatus(t *testing.T) {
+	var status blockchain.NodeStatus
+	assert.NoError(t, json.Unmarshal([]byte(statusResponse), &status))
+
+	assert.Equal(t, "5c2a752c9b1952dbed075c56c600c3a79b58c395", status.Result.NodeInfo.ID)
+	assert.Equal(t, "archway-1", status.Result.NodeInfo.Network)
+	assert.Equal(t, "0.37.2", status.Result.NodeInfo.Version)
+	assert.Equal(t, "11", status.Result.NodeInfo.ProtocolVersion.Block)
+	assert.Equal(t, "tcp://0.0.0.0:26657", status.Result.NodeInfo.Other.RPCAddress)
+	assert.Equal(t, "1000", status.Result.SyncInfo.LatestBlockHeight)
+	assert.Equal(t, time.Date(2023, 5, 4, 10, 0, 0, 123456789, time.UTC), status.Result.SyncInfo.LatestBlockTime)
+	assert.Equal(t, "1", status.Result.SyncInfo.EarliestBlockHeight)
+	assert.True(t, status.Result.SyncInfo.CatchingUp)
+}
+
+func TestCosmosCheckerCatchingUp(t *testing.T) {
+	ctx := context.Background()
+	// the node catching up reports the highest block height
+	ts1 := newLoopbackServer(t, "127.0.0.1", "0", http.HandlerFunc(handleGetRequest1))
+	defer ts1.Close()
+	port := serverPort(ts1)
+
+	ts2 := newLoopbackServer(t, "127.0.0.2", port, newStatusHandler(func(status *blockchain.NodeStatus) {
+		status.Result.SyncInfo.LatestBlockHeight = "1005"
+		status.Result.SyncInfo.CatchingUp = true
+	}))
+	defer ts2.Close()
+
+	targets := []string{"127.0.0.1", "127.0.0.2"}
+	results := blockchain.HealthCheck(ctx, blockchain.CosmosChecker{}, targets, blockchain.Config{
+		BlockMiss: 6,
+		RPC:       blockchain.RPCConfig{Port: port},
+	})
+
+	assert.Equal(t, []string{"127.0.0.1"}, blockchain.HealthyTargets(results))
+	assert.Equal(t, "node is catching up at block height 1005", results[1].Reason)
+	assert.Equal(t, 1005, results[1].BlockHeight)
+	// the node catching up is not used as the reference height
+	assert.Equal(t, 0, results[0].BlockLag)
+}
+
+// newStatusHandler serves a /status at block height 1000 changed by update.
+func newStatusHandler(update func(status *blockchain.NodeStatus)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		response := blockchain.NodeStatus{}
+		response.Result.SyncInfo.LatestBlockHeight = "1000"
+		update(&response)
+		_ = json.NewEncoder(w).Encode(response)
+	})
+}
+
+// newBlockTimeHandler serves a /status with the given block age.
+func newBlockTimeHandler(age time.Duration) http.Handler {
+	return newStatusHandler(func(status *blockchain.NodeStatus) {
+		status.Result.SyncInfo.LatestBlockTime = time.Now().Add(-age)
+	})
+}
+
+func TestCosmosCheckerMaxBlockAge(t *testing.T) {
+	ctx := context.Background()
+	ts1 := newLoopbackServer(t, "127.0.0.1", "0", newBlockTimeHandler(time.Second))
+	defer ts1.Close()
+	port := serverPort(ts1)
+
+	ts2 := newLoopbackServer(t, "127.0.0.2", port, newBlockTimeHandler(10*time.Minute))
+	defer ts2.Close()
+
+	config := blockchain.Config{
+		BlockMiss:   6,
+		RPC:         blockchain.RPCConfig{Port: port},
+		MaxBlockAge: time.Minute,
+	}
+	results := blockchain.HealthCheck(ctx, blockchain.CosmosChecker{}, []string{"127.0.0.1", "127.0.0.2"}, config)
+
+	// both nodes are at the same height, only the block age tells the stale one apart
+	assert.Equal(t, []string{"127.0.0.1"}, blockchain.HealthyTargets(results))
+	assert.Equal(t, "latest block 1000 is 10m0s old, older than the max block age 1m0s", results[1].Reason)
+
+	// the check is disabled by default
+	config.MaxBlockAge = 0
+	results = blockchain.HealthCheck(ctx, blockchain.CosmosChecker{}, []string{"127.0.0.1", "127.0.0.2"}, config)
+	assert.Equal(t, []string{"127.0.0.1", "127.0.0.2"}, blockchain.HealthyTargets(results))
+}
+
+func TestCosmosCheckerChainID(t *testing.T) {
+	ctx := context.Background()
+	ts1 := newLoopbackServer(t, "127.0.0.1", "0", newStatusHandler(func(status *blockchain.NodeStatus) {
+		status.Result.NodeInfo.Network = "archway-1"
+	}))
+	defer ts1.Close()
+	port := serverPort(ts1)
+
+	// the testnet node is ahead of the mainnet node
+	ts2 := newLoopbackServer(t, "127.0.0.2", port, newStatusHandler(func(status *blockchain.NodeStatus) {
+		status.Result.NodeInfo.Network = "constantine-3"
+		status.Result.SyncInfo.LatestBlockHeight = "5000"
+	}))
+	defer ts2.Close()
+
+	// the third target does not answer on /status
+	targets := []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"}
+	results := blockchain.HealthCheck(ctx, blockchain.CosmosChecker{}, targets, blockchain.Config{
+		BlockMiss: 6,
+		RPC:       blockchain.RPCConfig{Port: port},
+		ChainID:   "archway-1",
+	})
+
+	assert.Equal(t, []string{"127.0.0.1"}, blockchain.HealthyTargets(results))
+	assert.Equal(t, `node is on network "constantine-3", expected chain id "archway-1"`, results[1].Reason)
+	assert.Equal(t, 0, results[1].BlockHeight)
+	assert.Contains(t, results[2].Reason, `could not verify the chain id "archway-1": `)
+}
+
+func TestCosmosCheckerNodeID(t *testing.T) {
+	ctx := context.Background()
+	ts1 := newLoopbackServer(t, "127.0.0.1", "0", newStatusHandler(func(status *blockchain.NodeStatus) {
+		status.Result.NodeInfo.ID = "5c2a752c9b1952dbed075c56c600c3a79b58c395"
+	}))
+	defer ts1.Close()
+	port := serverPort(ts1)
+
+	// the IP of the second target was reassigned to another node
+	ts2 := newLoopbackServer(t, "127.0.0.2", port, newStatusHandler(func(status *blockchain.NodeStatus) {
+		status.Result.NodeInfo.ID = "0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c"
+	}))
+	defer ts2.Close()
+
+	targets := []string{"127.0.0.1", "127.0.0.2"}
+	results := blockchain.HealthCheck(ctx, blockchain.CosmosChecker{}, targets, blockchain.Config{
+		BlockMiss: 6,
+		RPC:       blockchain.RPCConfig{Port: port},
+		NodeIDs: map[string]string{
+			"127.0.0.1": "5c2a752c9b1952dbed075c56c600c3a79b58c395",
+			"127.0.0.2": "5c2a752c9b1952dbed075c56c600c3a79b58c395",
+		},
+	})
+
+	assert.Equal(t, []string{"127.0.0.1"}, blockchain.HealthyTargets(results))
+	assert.Equal(t, `node id "0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c" does not match `+
+		`the expected node id "5c2a752c9b1952dbed075c56c600c3a79b58c395"`, results[1].Reason)
+}
+
+func TestCosmosCheckerNodeIDClosedRPC(t *testing.T) {
+	ctx := context.Background()
+	ts := newLoopbackServer(t, "127.0.0.1", "0", newStatusHandler(func(status *blockchain.NodeStatus) {
+		status.Result.NodeInfo.ID = "5c2a752c9b1952dbed075c56c600c3a79b58c395"
+	}))
+	defer ts.Close()
+	port := serverPort(ts)
+
+	// the IP of the pinned second target was reassigned to a machine with a closed RPC port,
+	// the third target is not pinned
+	targets := []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"}
+	results := blockchain.HealthCheck(ctx, blockchain.CosmosChecker{}, targets, blockchain.Config{
+		BlockMiss: 6,
+		RPC:       blockchain.RPCConfig{Port: port},
+		NodeIDs: map[string]string{
+			"127.0.0.1": "5c2a752c9b1952dbed075c56c600c3a79b58c395",
+			"127.0.0.2": "5c2a752c9b1952dbed075c56c600c3a79b58c395",
+		},
+	})
+
+	assert.Equal(t, []string{"127.0.0.1", "127.0.0.3"}, blockchain.HealthyTargets(results))
+	assert.Contains(t, results[1].Reason,
+		`could not verify the node id "5c2a752c9b1952dbed075c56c600c3a79b58c395": `)
+}
+
+// newVersionHandler serves a /status with the CometBFT version and, when set,
+// the Cosmos REST node_info with the application version.
+func newVersionHandler(cometBFTVersion string, appVersion string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/status", newStatusHandler(func(status *blockchain.NodeStatus) {
+		status.Result.NodeInfo.Version = cometBFTVersion
+	}))
+	if appVersion != "" {
+		mux.HandleFunc("/cosmos/base/tendermint/v1beta1/node_info", func(w http.ResponseWriter, _ *http.Request) {
+			response := blockchain.CosmosNodeInfo{}
+			response.ApplicationVersion.Version = appVersion
+			_ = json.NewEncoder(w).Encode(response)
+		})
+	}
+	return mux
+}
+
+func TestCosmosCheckerVersion(t *testing.T) {
+	ts1 := newLoopbackServer(t, "127.0.0.1", "0", newVersionHandler("0.37.2", "v4.0.0"))
+	defer ts1.Close()
+	port := serverPort(ts1)
+
+	ts2 := newLoopbackServer(t, "127.0.0.2", port, newVersionHandler("0.34.28", "v3.9.0"))
+	defer ts2.Close()
+
+	ts3 := newLoopbackServer(t, "127.0.0.3", port, newVersionHandler("0.37.2", ""))
+	defer ts3.Close()
+
+	targets := []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"}
+	config := blockchain.Config{
+		BlockMiss: 6,
+		RPC:       blockchain.RPCConfig{Port: port},
+		APIPort:   port,
+	}
+
+	// the application version is checked by default
+	var err error
+	config.Version, err = semver.NewConstraint(">=v4.0.0")
+	assert.NoError(t, err)
+	results := blockchain.HealthCheck(context.Background(), blockchain.CosmosChecker{}, targets, config)
+	assert.Equal(t, []string{"127.0.0.1"}, blockchain.HealthyTargets(results))
+	assert.Equal(t, "application version v3.9.0 does not satisfy >=v4.0.0", results[1].Reason)
+	assert.Contains(t, results[2].Reason, "could not get the application version")
+
+	config.Version, err = semver.NewConstraint(">=0.37.0")
+	assert.NoError(t, err)
+	config.VersionSource = blockchain.VersionSourceCometBFT
+	results = blockchain.HealthCheck(context.Background(), blockchain.CosmosChecker{}, targets, config)
+	assert.Equal(t, []string{"127.0.0.1", "127.0.0.3"}, blockchain.HealthyTargets(results))
+	assert.Equal(t, "CometBFT version 0.34.28 does not satisfy >=0.37.0", results[1].Reason)
+}
+
+// newPeersHandler serves /rpc/status and /rpc/net_info with the number of peers.
+func newPeersHandler(peers string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/rpc/status", newStatusHandler(func(*blockchain.NodeStatus) {}))
+	mux.HandleFunc("/rpc/net_info", func(w http.ResponseWriter, _ *http.Request) {
+		response := blockchain.NetInfo{}
+		response.Result.NPeers = peers
+		_ = json.NewEncoder(w).Encode(response)
+	})
+	return mux
+}
+
+func TestCosmosCheckerMinPeers(t *testing.T) {
+	ctx := context.Background()
+	ts1 := newLoopbackServer(t, "127.0.0.1", "0", newPeersHandler("5"))
+	defer ts1.Close()
+	port := serverPort(ts1)
+
+	ts2 := newLoopbackServer(t, "127.0.0.2", port, newPeersHandler("1"))
+	defer ts2.Close()
+
+	// /net_info is read next to the configured /status path
+	config := blockchain.Config{
+		BlockMiss: 6,
+		RPC:       blockchain.RPCConfig{Port: port, Path: "/rpc/status"},
+		MinPeers:  2,
+	}
+	results := blockchain.HealthCheck(ctx, blockchain.CosmosChecker{}, []string{"127.0.0.1", "127.0.0.2"}, config)
+
+	assert.Equal(t, []string{"127.0.0.1"}, blockchain.HealthyTargets(results))
+	assert.Equal(t, "node has 1 peers, minimum is 2", results[1].Reason)
+	assert.Equal(t, 1000, results[1].BlockHeight)
+}
+
+func TestCosmosCheckerMaxEarliestHeight(t *testing.T) {
+	ctx := context.Background()
+	ts1 := newLoopbackServer(t, "127.0.0.1", "0", newStatusHandler(func(status *blockchain.NodeStatus) {
+		status.Result.SyncInfo.EarliestBlockHeight = "1"
+	}))
+	defer ts1.Close()
+	port := serverPort(ts1)
+
+	// the pruned node only keeps the recent blocks
+	ts2 := newLoopbackServer(t, "127.0.0.2", port, newStatusHandler(func(status *blockchain.NodeStatus) {
+		status.Result.SyncInfo.EarliestBlockHeight = "900"
+	}))
+	defer ts2.Close()
+
+	targets := []string{"127.0.0.1", "127.0.0.2"}
+	results := blockchain.HealthCheck(ctx, blockchain.CosmosChecker{}, targets, blockchain.Config{
+		BlockMiss:         6,
+		RPC:               blockchain.RPCConfig{Port: port},
+		MaxEarliestHeight: 1,
+	})
+
+	assert.Equal(t, []string{"127.0.0.1"}, blockchain.HealthyTargets(results))
+	assert.Equal(t, "earliest block height 900 is above 1, the node is pruned", results[1].Reason)
+}
+
+func TestCosmosCheckerRejectValidators(t *testing.T) {
+	ctx := context.Background()
+	ts1 := newLoopbackServer(t, "127.0.0.1", "0", newStatusHandler(func(status *blockchain.NodeStatus) {
+		status.Result.ValidatorInfo.VotingPower = "0"
+	}))
+	defer ts1.Close()
+	port := serverPort(ts1)
+
+	ts2 := newLoopbackServer(t, "127.0.0.2", port, newStatusHandler(func(status *blockchain.NodeStatus) {
+		status.Result.ValidatorInfo.VotingPower = "1250000"
+	}))
+	defer ts2.Close()
+
+	config := blockchain.Config{
+		BlockMiss: 6,
+		RPC:       blockchain.RPCConfig{Port: port},
+	}
+
+	// the third target does not answer on /status
+	targets := []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"}
+
+	// validators are only rejected on request
+	results := blockchain.HealthCheck(ctx, blockchain.CosmosChecker{}, targets, config)
+	assert.Equal(t, targets, blockchain.HealthyTargets(results))
+
+	config.RejectValidators = true
+	results = blockchain.HealthCheck(ctx, blockchain.CosmosChecker{}, targets, config)
+	assert.Equal(t, []string{"127.0.0.1"}, blockchain.HealthyTargets(results))
+	assert.True(t, results[1].Validator)
+	assert.True(t, results[1].Rejected)
+	assert.Equal(t, "node is a validator with voting power 1250000", results[1].Reason)
+	assert.False(t, results[2].Validator)
+	assert.Contains(t, results[2].Reason, "could not verify that the node is not a validator: ")
+}
@@ -0,0 +1,155 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultEVMRPCPort = "8545"
+	defaultEVMRPCPath = "/"
+)
+
+// EVMChecker checks Ethereum and EVM compatible nodes, every port has to accept
+// TCP connections, the node must not be syncing and the block height is read
+// with eth_blockNumber over JSON-RPC.
+type EVMChecker struct{}
+
+type jsonRPCRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+	ID      int           `json:"id"`
+}
+
+type jsonRPCResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// EVMSyncStatus is the eth_syncing result of a node that is syncing.
+type EVMSyncStatus struct {
+	CurrentBlock string `json:"currentBlock"`
+	HighestBlock string `json:"highestBlock"`
+}
+
+// Check checks a single EVM node.
+func (EVMChecker) Check(ctx context.Context, target string, config Config) TargetHealth {
+	result := TargetHealth{Target: target, Healthy: true}
+
+	checks, err := checkOpenPorts(ctx, target, config.Ports)
+	result.Ports = checks
+	if err != nil {
+		klog.Error(err)
+		result.Healthy = false
+		result.Reason = err.Error()
+		return result
+	}
+
+	url := config.RPC.URL(target, defaultEVMRPCPort, defaultEVMRPCPath)
+
+	// syncing nodes are unhealthy regardless of their block height
+	status, syncing, err := evmSyncing(ctx, config.RPC, url)
+	if err != nil {
+		klog.Error(err)
+		return result
+	}
+	if syncing {
+		result.Healthy = false
+		result.Reason = fmt.Sprintf("node is syncing, current block %s highest block %s",
+			status.CurrentBlock, status.HighestBlock)
+		return result
+	}
+
+	// nodes that do not answer eth_blockNumber are not compared
+	height, err := evmBlockNumber(ctx, config.RPC, url)
+	if err != nil {
+		klog.Error(err)
+		return result
+	}
+	result.BlockHeight = height
+
+	return result
+}
+
+// ReferenceHeight reads the eth_blockNumber height of a trusted JSON-RPC URL,
+// the probe settings of the targets are not sent to it.
+func (EVMChecker) ReferenceHeight(ctx context.Context, url string, _ Config) (int, error) {
+	return evmBlockNumber(ctx, RPCConfig{}, url)
+}
+
+// evmCall calls a JSON-RPC method without parameters and returns its result.
+func evmCall(ctx context.Context, rpc RPCConfig, url string, method string) (json.RawMessage, error) {
+	body, err := json.Marshal(jsonRPCRequest{
+		JSONRPC: "2.0",
+		Method:  method,
+		Params:  []interface{}{},
+		ID:      1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := postRequest(ctx, rpc, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var response jsonRPCResponse
+	if err = json.Unmarshal(data, &response); err != nil {
+		return nil, err
+	}
+	if response.Error != nil {
+		return nil, fmt.Errorf("%s %s failed: %s", url, method, response.Error.Message)
+	}
+
+	return response.Result, nil
+}
+
+// evmSyncing returns the sync status of the node and whether it is syncing,
+// eth_syncing returns false when the node is in sync.
+func evmSyncing(ctx context.Context, rpc RPCConfig, url string) (EVMSyncStatus, bool, error) {
+	var status EVMSyncStatus
+	klog.Infof("checking node sync status on %s", url)
+	result, err := evmCall(ctx, rpc, url, "eth_syncing")
+	if err != nil {
+		return status, false, err
+	}
+
+	var syncing bool
+	if err = json.Unmarshal(result, &syncing); err == nil {
+		return status, syncing, nil
+	}
+
+	if err = json.Unmarshal(result, &status); err != nil {
+		return status, false, err
+	}
+	return status, true, nil
+}
+
+// evmBlockNumber gets the latest block height with eth_blockNumber.
+func evmBlockNumber(ctx context.Context, rpc RPCConfig, url string) (int, error) {
+	klog.Infof("checking node block height on %s", url)
+	result, err := evmCall(ctx, rpc, url, "eth_blockNumber")
+	if err != nil {
+		return 0, err
+	}
+
+	var blockNumber string
+	if err = json.Unmarshal(result, &blockNumber); err != nil {
+		return 0, err
+	}
+
+	height, err := strconv.ParseInt(blockNumber, 0, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int(height), nil
+}
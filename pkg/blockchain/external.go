@@ -0,0 +1,73 @@
+package blockchain
+
+import (
+	"context"
+
+	"k8s.io/klog/v2"
+)
+
+// external reference modes select how the height of the trusted reference RPCs
+// is used by the block miss rule.
+const (
+	// ExternalReferenceCombined uses the highest of the external and the pool reference heights.
+	ExternalReferenceCombined = "combined"
+	// ExternalReferenceOnly uses the external reference height alone.
+	ExternalReferenceOnly = "external"
+)
+
+// ReferenceHeighter is implemented by checkers able to read the block height of a trusted
+// RPC URL, HealthCheck uses it when Config.ReferenceURLs is set.
+type ReferenceHeighter interface {
+	ReferenceHeight(ctx context.Context, url string, config Config) (int, error)
+}
+
+// referenceResult is the block height read from a reference URL.
+type referenceResult struct {
+	height int
+	err    error
+}
+
+// externalHeight
+// returns the highest block height of the reference URLs, read concurrently outside of the limiter
+// unreachable URLs and URLs that did not answer before the deadline are skipped
+// 0 is returned when none answered.
+func externalHeight(ctx context.Context, referencer ReferenceHeighter, config Config) int {
+	results, finished := concurrently(ctx, config.ReferenceURLs, nil,
+		func(ctx context.Context, url string) referenceResult {
+			height, err := referencer.ReferenceHeight(ctx, url, config)
+			return referenceResult{height: height, err: err}
+		})
+
+	var highest int
+	for i, url := range config.ReferenceURLs {
+		switch {
+		case !finished[i]:
+			klog.Errorf("could not get the reference height from %s: %s", url, ctx.Err())
+		case results[i].err != nil:
+			klog.Errorf("could not get the reference height from %s: %s", url, results[i].err)
+		default:
+			klog.Infof("reference %s block height %d", url, results[i].height)
+			if results[i].height > highest {
+				highest = results[i].height
+			}
+		}
+	}
+
+	if highest == 0 && len(config.ReferenceURLs) > 0 {
+		klog.Error("no reference RPC answered, falling back to the heights of the targets")
+	}
+	return highest
+}
+
+// combineReference returns the reference height of the block miss rule
+// from the pool and the external reference heights.
+func combineReference(pool int, external int, config Config) int {
+	switch {
+	case external == 0:
+		return pool
+	case config.ExternalReference == ExternalReferenceOnly || external > pool:
+		return external
+	default:
+		return pool
+	}
+}
@@ -0,0 +1,82 @@
+package blockchain_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/archway-network/endpoint-controller/pkg/blockchain"
+)
+
+// referenceChecker reports fixed block heights for the targets and the reference URLs,
+// unknown reference URLs are unreachable.
+type referenceChecker struct {
+	staticChecker
+	references map[string]int
+}
+
+func (r referenceChecker) ReferenceHeight(_ context.Context, url string, _ blockchain.Config) (int, error) {
+	height, ok := r.references[url]
+	if !ok {
+		return 0, errors.New("connection refused")
+	}
+	return height, nil
+}
+
+func TestExternalReference(t *testing.T) {
+	checker := referenceChecker{
+		staticChecker: staticChecker{"1.1.1.1": 1000, "2.2.2.2": 1002, "3.3.3.3": 998},
+		references:    map[string]int{"https://ahead": 1100, "https://behind": 990, "https://close": 1001},
+	}
+	ips := []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}
+
+	tests := []struct {
+		name       string
+		references []string
+		mode       string
+		healthy    []string
+		reference  int
+	}{
+		{"without reference", nil, "", []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}, 1002},
+		{"targets fell behind together", []string{"https://ahead"}, "", nil, 1100},
+		{"combined keeps the pool height", []string{"https://behind"}, blockchain.ExternalReferenceCombined,
+			[]string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}, 1002},
+		{"highest reference", []string{"https://behind", "https://ahead"}, "", nil, 1100},
+		{"external only", []string{"https://close"}, blockchain.ExternalReferenceOnly,
+			[]string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}, 1001},
+		{"unreachable reference", []string{"https://down"}, blockchain.ExternalReferenceOnly,
+			[]string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}, 1002},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			results := blockchain.HealthCheck(context.Background(), checker, ips, blockchain.Config{
+				BlockMiss:         6,
+				ReferenceURLs:     test.references,
+				ExternalReference: test.mode,
+			})
+
+			assert.Equal(t, test.healthy, blockchain.HealthyTargets(results))
+			assert.Equal(t, test.reference-1000, results[0].BlockLag)
+		})
+	}
+}
+
+func TestCosmosReferenceHeight(t *testing.T) {
+	ts := httptest.NewServer(newStatusHandler(func(status *blockchain.NodeStatus) {
+		status.Result.NodeInfo.Network = "constantine-3"
+		status.Result.SyncInfo.LatestBlockHeight = "1234"
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	height, err := blockchain.CosmosChecker{}.ReferenceHeight(ctx, ts.URL+"/status", blockchain.Config{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1234, height)
+
+	_, err = blockchain.CosmosChecker{}.ReferenceHeight(ctx, ts.URL+"/status", blockchain.Config{ChainID: "archway-1"})
+	assert.ErrorContains(t, err, `is on network "constantine-3", expected chain id "archway-1"`)
+}
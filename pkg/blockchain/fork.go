@@ -0,0 +1,95 @@
+package blockchain
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/klog/v2"
+)
+
+// BlockHashes identifies the chain state of a node at a block height.
+type BlockHashes struct {
+	BlockHash string
+	AppHash   string
+}
+
+// BlockHasher is implemented by checkers able to read the hashes of a block,
+// HealthCheck uses it to find targets on a fork when Config.ForkCheck is set.
+type BlockHasher interface {
+	BlockHashes(ctx context.Context, target string, height int, config Config) (BlockHashes, error)
+}
+
+// hashResult is the block hashes read from a target.
+type hashResult struct {
+	hashes BlockHashes
+	err    error
+}
+
+// checkForks
+// reads the block hashes of every healthy target at the lowest height they all reported,
+// concurrently outside of the limiter so hanging reads cannot starve the others
+// marks the targets whose hashes differ from the majority unhealthy
+// targets that do not answer before the deadline are not compared,
+// nothing is marked without a strict majority.
+func checkForks(ctx context.Context, hasher BlockHasher, results []TargetHealth, config Config) {
+	var height int
+	for _, result := range results {
+		if result.Healthy && result.BlockHeight > 0 && (height == 0 || result.BlockHeight < height) {
+			height = result.BlockHeight
+		}
+	}
+	if height == 0 {
+		return
+	}
+
+	var compared []int
+	for i, result := range results {
+		if result.Healthy && result.BlockHeight > 0 {
+			compared = append(compared, i)
+		}
+	}
+	answers, finished := concurrently(ctx, compared, nil, func(ctx context.Context, i int) hashResult {
+		h, err := hasher.BlockHashes(ctx, results[i].Target, height, config)
+		return hashResult{hashes: h, err: err}
+	})
+
+	hashes := map[int]BlockHashes{}
+	votes := map[BlockHashes]int{}
+	for j, i := range compared {
+		if !finished[j] {
+			klog.Errorf("could not get the block hashes of %s: %s", results[i].Target, ctx.Err())
+			continue
+		}
+		if answers[j].err != nil {
+			klog.Error(answers[j].err)
+			continue
+		}
+		hashes[i] = answers[j].hashes
+		votes[answers[j].hashes]++
+	}
+
+	var majority BlockHashes
+	var majorityVotes int
+	for h, count := range votes {
+		if count > majorityVotes {
+			majority, majorityVotes = h, count
+		}
+	}
+	if majorityVotes*2 <= len(hashes) { //nolint: gomnd // strict majority
+		if len(votes) > 1 {
+			klog.Errorf("targets disagree on block %d without a majority, no target is evicted", height)
+		}
+		return
+	}
+
+	for i, h := range hashes {
+		if h == majority {
+			continue
+		}
+		klog.Errorf("target %s is on a fork at block %d", results[i].Target, height)
+		results[i].Healthy = false
+		results[i].Rejected = true
+		results[i].Reason = fmt.Sprintf("block %d hash %s app hash %s diverges from the majority hash %s app hash %s",
+			height, h.BlockHash, h.AppHash, majority.BlockHash, majority.AppHash)
+	}
+}
@@ -0,0 +1,77 @@
+package blockchain_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/archway-network/endpoint-controller/pkg/blockchain"
+)
+
+// newForkHandler serves a /status at the height and a /block with the hash at every height.
+func newForkHandler(height string, hash string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/status", newStatusHandler(func(status *blockchain.NodeStatus) {
+		status.Result.SyncInfo.LatestBlockHeight = height
+	}))
+	mux.HandleFunc("/block", func(w http.ResponseWriter, r *http.Request) {
+		response := blockchain.Block{}
+		response.Result.BlockID.Hash = hash
+		response.Result.Block.Header.Height = r.URL.Query().Get("height")
+		response.Result.Block.Header.AppHash = "APP" + hash
+		_ = json.NewEncoder(w).Encode(response)
+	})
+	return mux
+}
+
+func TestForkCheck(t *testing.T) {
+	ts1 := newLoopbackServer(t, "127.0.0.1", "0", newForkHandler("1000", "AAAA"))
+	defer ts1.Close()
+	port := serverPort(ts1)
+
+	ts2 := newLoopbackServer(t, "127.0.0.2", port, newForkHandler("1002", "AAAA"))
+	defer ts2.Close()
+
+	// the forked node is ahead of the others
+	ts3 := newLoopbackServer(t, "127.0.0.3", port, newForkHandler("1050", "BBBB"))
+	defer ts3.Close()
+
+	targets := []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"}
+	config := blockchain.Config{
+		BlockMiss: 6,
+		RPC:       blockchain.RPCConfig{Port: port},
+		ForkCheck: true,
+	}
+
+	results := blockchain.HealthCheck(context.Background(), blockchain.CosmosChecker{}, targets, config)
+	assert.Equal(t, []string{"127.0.0.1", "127.0.0.2"}, blockchain.HealthyTargets(results))
+	assert.Equal(t, "block 1000 hash BBBB app hash APPBBBB diverges from the majority hash AAAA app hash APPAAAA",
+		results[2].Reason)
+	assert.Equal(t, []string{"127.0.0.1", "127.0.0.2"}, blockchain.TrustedTargets(results))
+
+	// without the fork check the forked node is the reference height
+	config.ForkCheck = false
+	results = blockchain.HealthCheck(context.Background(), blockchain.CosmosChecker{}, targets, config)
+	assert.Equal(t, []string{"127.0.0.3"}, blockchain.HealthyTargets(results))
+}
+
+func TestForkCheckWithoutMajority(t *testing.T) {
+	ctx := context.Background()
+	ts1 := newLoopbackServer(t, "127.0.0.1", "0", newForkHandler("1000", "AAAA"))
+	defer ts1.Close()
+	port := serverPort(ts1)
+
+	ts2 := newLoopbackServer(t, "127.0.0.2", port, newForkHandler("1000", "BBBB"))
+	defer ts2.Close()
+
+	targets := []string{"127.0.0.1", "127.0.0.2"}
+	results := blockchain.HealthCheck(ctx, blockchain.CosmosChecker{}, targets, blockchain.Config{
+		BlockMiss: 6,
+		RPC:       blockchain.RPCConfig{Port: port},
+		ForkCheck: true,
+	})
+	assert.Equal(t, []string{"127.0.0.1", "127.0.0.2"}, blockchain.HealthyTargets(results))
+}
@@ -0,0 +1,112 @@
+package blockchain
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/klog/v2"
+)
+
+// reference strategies select how the reference height of the block miss rule
+// is computed from the heights of the healthy targets.
+const (
+	// ReferenceMax uses the highest height.
+	ReferenceMax = "max"
+	// ReferenceMedian uses the median height.
+	ReferenceMedian = "median"
+	// ReferenceQuorum uses the highest height reached by at least Config.ReferenceQuorum targets.
+	ReferenceQuorum = "quorum"
+	// ReferenceTrimmedMax uses the highest height once the heights more than
+	// Config.MaxBlocksAhead blocks ahead of the rest are ignored,
+	// as long as the heights left are a majority.
+	ReferenceTrimmedMax = "trimmed-max"
+)
+
+// referenceHeight returns the reference height of the heights sorted in descending order.
+func referenceHeight(heights []int, config Config) int {
+	switch config.ReferenceStrategy {
+	case ReferenceMedian:
+		return heights[(len(heights)-1)/2] //nolint: gomnd // the upper middle of the sorted heights
+	case ReferenceQuorum:
+		quorum := config.ReferenceQuorum
+		if quorum < 1 {
+			quorum = 1
+		}
+		if quorum > len(heights) {
+			quorum = len(heights)
+		}
+		return heights[quorum-1]
+	case ReferenceTrimmedMax:
+		return trimmedMax(heights, config)
+	default:
+		return heights[0]
+	}
+}
+
+// trimmedMax returns the highest of the heights sorted in descending order once the heights
+// more than Config.MaxBlocksAhead blocks ahead of the next one are ignored,
+// as long as the heights left are a majority.
+func trimmedMax(heights []int, config Config) int {
+	// a lagging majority must not trim the nodes in sync
+	maxAhead := maxBlocksAhead(config)
+	majority := len(heights)/2 + 1 //nolint: gomnd // strict majority
+	i := 0
+	for len(heights)-(i+1) >= majority && heights[i]-heights[i+1] > maxAhead {
+		i++
+	}
+	return heights[i]
+}
+
+// maxBlocksAhead returns how many blocks a target can be ahead of the reference height,
+// the block miss is used when Config.MaxBlocksAhead is not set.
+func maxBlocksAhead(config Config) int {
+	if config.MaxBlocksAhead > 0 {
+		return config.MaxBlocksAhead
+	}
+	return config.BlockMiss
+}
+
+// compareBlockHeights
+// computes the reference height of the healthy targets with the reference strategy
+// the external height of the reference URLs replaces or raises it, see combineReference
+// marks the targets falling more than BlockMiss blocks behind the reference unhealthy
+// marks the targets more than MaxBlocksAhead blocks ahead of the reference unhealthy,
+// the targets above a median or quorum reference only when trimmed-max would ignore them
+// targets without a block height are not compared.
+func compareBlockHeights(results []TargetHealth, config Config, external int) {
+	heights := make([]int, 0, len(results))
+	for _, result := range results {
+		if result.Healthy && result.BlockHeight > 0 {
+			heights = append(heights, result.BlockHeight)
+		}
+	}
+	if len(heights) == 0 {
+		return
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(heights)))
+	reference := combineReference(referenceHeight(heights, config), external, config)
+	maxAhead := maxBlocksAhead(config)
+	// the nodes in sync above a median or quorum reference are not outliers
+	highest := reference
+	if trimmed := trimmedMax(heights, config); config.ExternalReference != ExternalReferenceOnly && trimmed > highest {
+		highest = trimmed
+	}
+
+	for i := range results {
+		if !results[i].Healthy || results[i].BlockHeight == 0 {
+			continue
+		}
+		results[i].BlockLag = reference - results[i].BlockHeight
+		switch {
+		case results[i].BlockLag > config.BlockMiss:
+			results[i].Healthy = false
+			results[i].Reason = fmt.Sprintf("block height %d is %d blocks behind %d",
+				results[i].BlockHeight, results[i].BlockLag, reference)
+		case results[i].BlockHeight-highest > maxAhead:
+			klog.Errorf("target %s is an outlier at block height %d", results[i].Target, results[i].BlockHeight)
+			results[i].Healthy = false
+			results[i].Reason = fmt.Sprintf("block height %d is %d blocks ahead of the reference height %d",
+				results[i].BlockHeight, -results[i].BlockLag, reference)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package blockchain_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/archway-network/endpoint-controller/pkg/blockchain"
+)
+
+func TestReferenceHeight(t *testing.T) {
+	// 4.4.4.4 reports a bogus height far ahead of the rest
+	pool := []int{1000, 1002, 1001, 5000}
+
+	tests := []struct {
+		name      string
+		config    blockchain.Config
+		healthy   []string
+		reference int
+		// heights of the targets 1.1.1.1, 2.2.2.2... defaults to the pool
+		heights []int
+	}{
+		{"default", blockchain.Config{}, []string{"4.4.4.4"}, 5000, nil},
+		{"max", blockchain.Config{ReferenceStrategy: blockchain.ReferenceMax}, []string{"4.4.4.4"}, 5000, nil},
+		{"median", blockchain.Config{ReferenceStrategy: blockchain.ReferenceMedian},
+			[]string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}, 1002, nil},
+		{"median of an even number of targets", blockchain.Config{ReferenceStrategy: blockchain.ReferenceMedian},
+			[]string{"1.1.1.1", "2.2.2.2"}, 1010, []int{1010, 1010, 1000, 1000}},
+		{"quorum", blockchain.Config{ReferenceStrategy: blockchain.ReferenceQuorum, ReferenceQuorum: 2},
+			[]string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}, 1002, nil},
+		{"quorum above the targets", blockchain.Config{
+			ReferenceStrategy: blockchain.ReferenceQuorum, ReferenceQuorum: 10,
+		}, []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}, 1000, nil},
+		{"quorum keeps the nodes in sync ahead", blockchain.Config{
+			ReferenceStrategy: blockchain.ReferenceQuorum, ReferenceQuorum: 2,
+		}, []string{"1.1.1.1", "2.2.2.2"}, 1000, []int{1010, 1000}},
+		{"trimmed max", blockchain.Config{ReferenceStrategy: blockchain.ReferenceTrimmedMax},
+			[]string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}, 1002, nil},
+		{"trimmed max within max blocks ahead", blockchain.Config{
+			ReferenceStrategy: blockchain.ReferenceTrimmedMax, MaxBlocksAhead: 5000,
+		}, []string{"4.4.4.4"}, 5000, nil},
+		{"trimmed max keeps the node in sync of two", blockchain.Config{
+			ReferenceStrategy: blockchain.ReferenceTrimmedMax,
+		}, []string{"1.1.1.1"}, 100, []int{100, 90}},
+		{"trimmed max only trims a minority", blockchain.Config{
+			ReferenceStrategy: blockchain.ReferenceTrimmedMax,
+		}, []string{"2.2.2.2"}, 98, []int{105, 98, 91, 84}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			heights := test.heights
+			if heights == nil {
+				heights = pool
+			}
+			checker := staticChecker{}
+			ips := make([]string, 0, len(heights))
+			for i, height := range heights {
+				ip := fmt.Sprintf("%d.%d.%d.%d", i+1, i+1, i+1, i+1)
+				checker[ip] = height
+				ips = append(ips, ip)
+			}
+
+			test.config.BlockMiss = 6
+			results := blockchain.HealthCheck(context.Background(), checker, ips, test.config)
+
+			assert.Equal(t, test.healthy, blockchain.HealthyTargets(results))
+			assert.Equal(t, test.reference-heights[0], results[0].BlockLag)
+		})
+	}
+}
+
+func TestOutlierReason(t *testing.T) {
+	checker := staticChecker{"1.1.1.1": 1000, "2.2.2.2": 1002, "3.3.3.3": 1001, "4.4.4.4": 5000}
+	ips := []string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "4.4.4.4"}
+
+	results := blockchain.HealthCheck(context.Background(), checker, ips, blockchain.Config{
+		BlockMiss:         6,
+		ReferenceStrategy: blockchain.ReferenceTrimmedMax,
+		MaxBlocksAhead:    100,
+	})
+
+	assert.False(t, results[3].Healthy)
+	assert.Equal(t, "block height 5000 is 3998 blocks ahead of the reference height 1002", results[3].Reason)
+	assert.Equal(t, 2, results[0].BlockLag)
+}
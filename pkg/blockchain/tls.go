@@ -0,0 +1,36 @@
+package blockchain
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+)
+
+// NewTLSConfig returns the TLS settings of https probes.
+// ca is a PEM bundle verifying the nodes, the system roots are used when it is empty.
+// cert and key are a PEM client certificate and key presented to the nodes, both or none are set.
+// serverName overrides the SNI and the name verified in the node certificate.
+func NewTLSConfig(ca, cert, key []byte, serverName string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+
+	if len(ca) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("CA bundle holds no PEM certificate")
+		}
+		config.RootCAs = pool
+	}
+
+	if len(cert) > 0 || len(key) > 0 {
+		certificate, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
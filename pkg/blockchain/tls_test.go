@@ -0,0 +1,87 @@
+package blockchain_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/archway-network/endpoint-controller/pkg/blockchain"
+)
+
+// newTLSServer starts a /status server requiring a client certificate, a bearer token
+// and the rpc.example.com Host header, it returns the server and its PEM certificate and key.
+func newTLSServer(t *testing.T) (*httptest.Server, []byte, []byte) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret-token" || r.Host != "rpc.example.com" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handleGetRequest1(w, r)
+	}))
+	ts.TLS = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientAuth: tls.RequireAnyClientCert,
+	}
+	ts.StartTLS()
+
+	key, err := x509.MarshalPKCS8PrivateKey(ts.TLS.Certificates[0].PrivateKey)
+	assert.NoError(t, err)
+
+	return ts,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+}
+
+func TestTLSProbe(t *testing.T) {
+	ts, cert, key := newTLSServer(t)
+	defer ts.Close()
+
+	// the test certificate is valid for example.com, the client reuses it
+	tlsConfig, err := blockchain.NewTLSConfig(cert, cert, key, "example.com")
+	assert.NoError(t, err)
+	withoutClientCert, err := blockchain.NewTLSConfig(cert, nil, nil, "example.com")
+	assert.NoError(t, err)
+	header := http.Header{"Authorization": []string{"Bearer secret-token"}}
+
+	tests := []struct {
+		name   string
+		rpc    blockchain.RPCConfig
+		height int
+	}{
+		{"authenticated", blockchain.RPCConfig{TLS: tlsConfig, Host: "rpc.example.com", Header: header}, 1000},
+		{"without token", blockchain.RPCConfig{TLS: tlsConfig, Host: "rpc.example.com"}, 0},
+		{"without client certificate", blockchain.RPCConfig{
+			TLS: withoutClientCert, Host: "rpc.example.com", Header: header,
+		}, 0},
+		{"without CA", blockchain.RPCConfig{Host: "rpc.example.com", Header: header}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.rpc.Scheme = "https"
+			test.rpc.Port = serverPort(ts)
+
+			result := blockchain.CosmosChecker{}.Check(context.Background(), "127.0.0.1", blockchain.Config{RPC: test.rpc})
+			assert.Equal(t, test.height, result.BlockHeight)
+		})
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	_, err := blockchain.NewTLSConfig([]byte("not a certificate"), nil, nil, "")
+	assert.Error(t, err)
+
+	_, err = blockchain.NewTLSConfig(nil, []byte("not a certificate"), nil, "")
+	assert.Error(t, err)
+
+	config, err := blockchain.NewTLSConfig(nil, nil, nil, "node.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "node.example.com", config.ServerName)
+	assert.Nil(t, config.RootCAs)
+}
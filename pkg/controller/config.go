@@ -0,0 +1,443 @@
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/archway-network/endpoint-controller/pkg/blockchain"
+)
+
+const maxPort = 65535
+
+// keys of the secret referenced by the endpoint-controller/rpc-secret annotation.
+const (
+	RPCSecretCAKey       = "ca.crt"
+	RPCSecretCertKey     = corev1.TLSCertKey
+	RPCSecretKeyKey      = corev1.TLSPrivateKeyKey
+	RPCSecretTokenKey    = "token"
+	RPCSecretUsernameKey = corev1.BasicAuthUsernameKey
+	RPCSecretPasswordKey = corev1.BasicAuthPasswordKey
+)
+
+// checker returns the checker selected by the endpoint-controller/checker annotation.
+func (c *Controller) checker(service corev1.Service) (blockchain.Checker, error) {
+	name := blockchain.DefaultChecker
+	if value, ok := service.Annotations[EndpointControllerChecker]; ok {
+		name = strings.TrimSpace(value)
+	}
+
+	checker, ok := c.Checkers.Get(name)
+	if !ok {
+		return nil, &InvalidAnnotationError{
+			Service:    service.Name,
+			Annotation: EndpointControllerChecker,
+			Message: fmt.Sprintf("has unknown checker %q, available checkers are %s",
+				name, strings.Join(c.Checkers.Names(), ", ")),
+		}
+	}
+	return checker, nil
+}
+
+// healthCheckConfig returns the health check settings of the service.
+func (c *Controller) healthCheckConfig(ctx context.Context, service corev1.Service) (blockchain.Config, error) {
+	rpc, err := c.rpcConfig(ctx, service)
+	if err != nil {
+		return blockchain.Config{}, err
+	}
+
+	maxBlockAge, err := durationAnnotation(service, EndpointControllerMaxBlockAge, c.MaxBlockAge)
+	if err != nil {
+		return blockchain.Config{}, err
+	}
+
+	timeout, err := durationAnnotation(service, EndpointControllerCheckTimeout, c.CheckTimeout)
+	if err != nil {
+		return blockchain.Config{}, err
+	}
+
+	config := blockchain.Config{
+		Ports:       createEndpointPortObject(service),
+		BlockMiss:   c.BlockMiss,
+		RPC:         rpc,
+		MaxBlockAge: maxBlockAge,
+		ChainID:     strings.TrimSpace(service.Annotations[EndpointControllerChainID]),
+		NodeIDs:     serviceNodeIDs(service),
+		Limiter:     c.limiter,
+		Timeout:     timeout,
+	}
+	if err = versionConfig(service, &config); err != nil {
+		return blockchain.Config{}, err
+	}
+
+	if config.MinPeers, err = intAnnotation(service, EndpointControllerMinPeers, 0); err != nil {
+		return blockchain.Config{}, err
+	}
+	if config.MaxEarliestHeight, err = intAnnotation(service, EndpointControllerMaxEarliest, 0); err != nil {
+		return blockchain.Config{}, err
+	}
+
+	if config.RejectValidators, err = boolAnnotation(
+		service, EndpointControllerRejectValidators, c.RejectValidators); err != nil {
+		return blockchain.Config{}, err
+	}
+	if config.ForkCheck, err = boolAnnotation(service, EndpointControllerForkCheck, c.ForkCheck); err != nil {
+		return blockchain.Config{}, err
+	}
+
+	if err = c.referenceConfig(service, &config); err != nil {
+		return blockchain.Config{}, err
+	}
+
+	return config, nil
+}
+
+// referenceConfig sets how the reference height of the service targets is computed,
+// the controller defaults are overridden by the annotations.
+func (c *Controller) referenceConfig(service corev1.Service, config *blockchain.Config) error {
+	config.ReferenceStrategy = c.ReferenceHeight
+	if value, ok := service.Annotations[EndpointControllerReferenceHeight]; ok {
+		config.ReferenceStrategy = strings.TrimSpace(value)
+	}
+	switch config.ReferenceStrategy {
+	case "", blockchain.ReferenceMax, blockchain.ReferenceMedian,
+		blockchain.ReferenceQuorum, blockchain.ReferenceTrimmedMax:
+	default:
+		return &InvalidAnnotationError{
+			Service:    service.Name,
+			Annotation: EndpointControllerReferenceHeight,
+			Message: fmt.Sprintf("has invalid strategy %q, use %s, %s, %s or %s", config.ReferenceStrategy,
+				blockchain.ReferenceMax, blockchain.ReferenceMedian,
+				blockchain.ReferenceQuorum, blockchain.ReferenceTrimmedMax),
+		}
+	}
+
+	var err error
+	if config.ReferenceQuorum, err = intAnnotation(
+		service, EndpointControllerReferenceQuorum, c.ReferenceQuorum); err != nil {
+		return err
+	}
+	if config.MaxBlocksAhead, err = intAnnotation(
+		service, EndpointControllerMaxBlocksAhead, c.MaxBlocksAhead); err != nil {
+		return err
+	}
+
+	return externalReferenceConfig(service, config)
+}
+
+// externalReferenceConfig sets the trusted reference RPC URLs of the service
+// and how their height is combined with the targets.
+func externalReferenceConfig(service corev1.Service, config *blockchain.Config) error {
+	value, ok := service.Annotations[EndpointControllerReferenceRPC]
+	if !ok {
+		return nil
+	}
+
+	for _, reference := range strings.Split(value, ",") {
+		reference = strings.TrimSpace(reference)
+		if reference == "" {
+			continue
+		}
+		u, err := url.Parse(reference)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &InvalidAnnotationError{
+				Service:    service.Name,
+				Annotation: EndpointControllerReferenceRPC,
+				Message:    fmt.Sprintf("has invalid URL %q, use an http or https URL", reference),
+			}
+		}
+		config.ReferenceURLs = append(config.ReferenceURLs, reference)
+	}
+
+	config.ExternalReference = strings.TrimSpace(service.Annotations[EndpointControllerReferenceRPCMode])
+	switch config.ExternalReference {
+	case "", blockchain.ExternalReferenceCombined, blockchain.ExternalReferenceOnly:
+	default:
+		return &InvalidAnnotationError{
+			Service:    service.Name,
+			Annotation: EndpointControllerReferenceRPCMode,
+			Message: fmt.Sprintf("has invalid mode %q, use %s or %s", config.ExternalReference,
+				blockchain.ExternalReferenceCombined, blockchain.ExternalReferenceOnly),
+		}
+	}
+
+	return nil
+}
+
+// intAnnotation returns the non negative number set by the annotation or the default value.
+func intAnnotation(service corev1.Service, annotation string, defaultValue int) (int, error) {
+	value, ok := service.Annotations[annotation]
+	if !ok {
+		return defaultValue, nil
+	}
+
+	number, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || number < 0 {
+		return 0, &InvalidAnnotationError{
+			Service:    service.Name,
+			Annotation: annotation,
+			Message:    fmt.Sprintf("has invalid number %q", value),
+		}
+	}
+	return number, nil
+}
+
+// boolAnnotation returns the boolean set by the annotation or the default value.
+func boolAnnotation(service corev1.Service, annotation string, defaultValue bool) (bool, error) {
+	value, ok := service.Annotations[annotation]
+	if !ok {
+		return defaultValue, nil
+	}
+
+	b, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return false, &InvalidAnnotationError{
+			Service:    service.Name,
+			Annotation: annotation,
+			Message:    fmt.Sprintf("has invalid value %q, use true or false", value),
+		}
+	}
+	return b, nil
+}
+
+// versionConfig sets the version constraint of the service and where the versions are read.
+func versionConfig(service corev1.Service, config *blockchain.Config) error {
+	value, ok := service.Annotations[EndpointControllerVersion]
+	if !ok {
+		return nil
+	}
+
+	constraint, err := semver.NewConstraint(strings.TrimSpace(value))
+	if err != nil {
+		return &InvalidAnnotationError{
+			Service:    service.Name,
+			Annotation: EndpointControllerVersion,
+			Message:    fmt.Sprintf("has invalid version constraint %q: %s", value, err),
+		}
+	}
+	config.Version = constraint
+
+	config.VersionSource = strings.TrimSpace(service.Annotations[EndpointControllerVersionSource])
+	switch config.VersionSource {
+	case "", blockchain.VersionSourceApp, blockchain.VersionSourceCometBFT, blockchain.VersionSourceBoth:
+	default:
+		return &InvalidAnnotationError{
+			Service:    service.Name,
+			Annotation: EndpointControllerVersionSource,
+			Message: fmt.Sprintf("has invalid source %q, use %s, %s or %s", config.VersionSource,
+				blockchain.VersionSourceApp, blockchain.VersionSourceCometBFT, blockchain.VersionSourceBoth),
+		}
+	}
+
+	if value, ok = service.Annotations[EndpointControllerAPIPort]; ok {
+		config.APIPort, err = resolvePort(service, EndpointControllerAPIPort, strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// durationAnnotation returns the non negative duration set by the annotation or the default value.
+func durationAnnotation(service corev1.Service, annotation string, defaultValue time.Duration) (time.Duration, error) {
+	value, ok := service.Annotations[annotation]
+	if !ok {
+		return defaultValue, nil
+	}
+
+	duration, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil || duration < 0 {
+		return 0, &InvalidAnnotationError{
+			Service:    service.Name,
+			Annotation: annotation,
+			Message:    fmt.Sprintf("has invalid duration %q, use a duration like 90s or 0 to disable", value),
+		}
+	}
+	return duration, nil
+}
+
+// historyConfig returns the height history rules of the service,
+// the controller defaults are overridden by the annotations.
+func (c *Controller) historyConfig(service corev1.Service) (historyConfig, error) {
+	config := historyConfig{minBlockRate: c.MinBlockRate}
+
+	var err error
+	if config.stallChecks, err = intAnnotation(service, EndpointControllerStallChecks, c.StallChecks); err != nil {
+		return config, err
+	}
+
+	if value, ok := service.Annotations[EndpointControllerMinBlockRate]; ok {
+		config.minBlockRate, err = strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || config.minBlockRate < 0 || config.minBlockRate > 1 {
+			return config, &InvalidAnnotationError{
+				Service:    service.Name,
+				Annotation: EndpointControllerMinBlockRate,
+				Message:    fmt.Sprintf("has invalid fraction %q, use a number between 0 and 1", value),
+			}
+		}
+	}
+
+	return config, nil
+}
+
+// hysteresisConfig returns the rise and fall thresholds of the service,
+// the controller defaults are overridden by the annotations, thresholds below 1 are 1.
+func (c *Controller) hysteresisConfig(service corev1.Service) (hysteresisConfig, error) {
+	var config hysteresisConfig
+	var err error
+	if config.rise, err = intAnnotation(service, EndpointControllerRise, c.Rise); err != nil {
+		return config, err
+	}
+	if config.fall, err = intAnnotation(service, EndpointControllerFall, c.Fall); err != nil {
+		return config, err
+	}
+
+	if config.rise < 1 {
+		config.rise = 1
+	}
+	if config.fall < 1 {
+		config.fall = 1
+	}
+	return config, nil
+}
+
+// rpcConfig returns the block height probe settings of the service,
+// the controller defaults are overridden by the rpc annotations.
+func (c *Controller) rpcConfig(ctx context.Context, service corev1.Service) (blockchain.RPCConfig, error) {
+	rpc := c.RPC
+	if value, ok := service.Annotations[EndpointControllerRPCScheme]; ok {
+		rpc.Scheme = strings.TrimSpace(value)
+	}
+	if value, ok := service.Annotations[EndpointControllerRPCPort]; ok {
+		rpc.Port = strings.TrimSpace(value)
+	}
+	if value, ok := service.Annotations[EndpointControllerRPCPath]; ok {
+		rpc.Path = strings.TrimSpace(value)
+	}
+	if value, ok := service.Annotations[EndpointControllerRPCHost]; ok {
+		rpc.Host = strings.TrimSpace(value)
+	}
+
+	switch rpc.Scheme {
+	case "", "http", "https":
+	default:
+		return rpc, &InvalidAnnotationError{
+			Service:    service.Name,
+			Annotation: EndpointControllerRPCScheme,
+			Message:    fmt.Sprintf("has invalid scheme %q, use http or https", rpc.Scheme),
+		}
+	}
+
+	if rpc.Port != "" {
+		port, err := resolvePort(service, EndpointControllerRPCPort, rpc.Port)
+		if err != nil {
+			return rpc, err
+		}
+		rpc.Port = port
+	}
+
+	if err := c.rpcCredentials(ctx, service, &rpc); err != nil {
+		return rpc, err
+	}
+
+	return rpc, nil
+}
+
+// rpcCredentials
+// sets the TLS settings and auth header of the probes from the rpc-server-name annotation
+// and the secret referenced by the rpc-secret annotation
+// return error if the secret cannot be read or holds invalid data.
+func (c *Controller) rpcCredentials(ctx context.Context, service corev1.Service, rpc *blockchain.RPCConfig) error {
+	serverName := strings.TrimSpace(service.Annotations[EndpointControllerRPCSNI])
+	name := strings.TrimSpace(service.Annotations[EndpointControllerRPCSecret])
+	if serverName == "" && name == "" {
+		return nil
+	}
+
+	var data map[string][]byte
+	if name != "" {
+		// secrets are read one by one so the controller only needs get on the referenced ones
+		secret, err := c.Clientset.CoreV1().Secrets(service.Namespace).Get(ctx, name, v1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return &InvalidAnnotationError{
+				Service:    service.Name,
+				Annotation: EndpointControllerRPCSecret,
+				Message:    fmt.Sprintf("references missing secret %q", name),
+			}
+		}
+		if err != nil {
+			return c.apiError("secrets", "get", fmt.Errorf("%s : %w", service.Name, err))
+		}
+		data = secret.Data
+	}
+
+	invalidSecret := func(message string) error {
+		return &InvalidAnnotationError{
+			Service:    service.Name,
+			Annotation: EndpointControllerRPCSecret,
+			Message:    fmt.Sprintf("references secret %q %s", name, message),
+		}
+	}
+
+	tlsConfig, err := blockchain.NewTLSConfig(
+		data[RPCSecretCAKey], data[RPCSecretCertKey], data[RPCSecretKeyKey], serverName)
+	if err != nil {
+		return invalidSecret(fmt.Sprintf("with invalid TLS data: %s", err))
+	}
+	rpc.TLS = tlsConfig
+
+	token, hasToken := data[RPCSecretTokenKey]
+	username, hasUsername := data[RPCSecretUsernameKey]
+	switch {
+	case hasToken && hasUsername:
+		return invalidSecret(fmt.Sprintf("with both %s and %s, use one of them",
+			RPCSecretTokenKey, RPCSecretUsernameKey))
+	case hasToken:
+		rpc.Header = http.Header{"Authorization": []string{"Bearer " + strings.TrimSpace(string(token))}}
+	case hasUsername:
+		credentials := string(username) + ":" + string(data[RPCSecretPasswordKey])
+		rpc.Header = http.Header{"Authorization": []string{
+			"Basic " + base64.StdEncoding.EncodeToString([]byte(credentials)),
+		}}
+	}
+
+	return nil
+}
+
+// resolvePort returns the port number of a port number or named service port
+// set by the annotation.
+func resolvePort(service corev1.Service, annotation string, port string) (string, error) {
+	if number, err := strconv.Atoi(port); err == nil {
+		if number < 1 || number > maxPort {
+			return "", &InvalidAnnotationError{
+				Service:    service.Name,
+				Annotation: annotation,
+				Message:    fmt.Sprintf("has invalid port %d", number),
+			}
+		}
+		return port, nil
+	}
+
+	for _, servicePort := range service.Spec.Ports {
+		if servicePort.Name == port {
+			return strconv.Itoa(int(servicePort.Port)), nil
+		}
+	}
+
+	return "", &InvalidAnnotationError{
+		Service:    service.Name,
+		Annotation: annotation,
+		Message:    fmt.Sprintf("has unknown service port %q", port),
+	}
+}
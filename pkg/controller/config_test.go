@@ -0,0 +1,311 @@
+package controller_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/archway-network/endpoint-controller/pkg/blockchain"
+	"github.com/archway-network/endpoint-controller/pkg/controller"
+)
+
+// newStatusServer starts a CometBFT /status stand-in on the given loopback ip and port.
+func newStatusServer(t *testing.T, ip string, port int, height string) *httptest.Server {
+	listener, err := net.Listen("tcp", net.JoinHostPort(ip, strconv.Itoa(port)))
+	if err != nil {
+		t.Skipf("could not listen on %s: %v", ip, err)
+	}
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		response := blockchain.NodeStatus{}
+		response.Result.SyncInfo.LatestBlockHeight = height
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	ts.Listener.Close()
+	ts.Listener = listener
+	ts.Start()
+	return ts
+}
+
+// newStatusTestObjects returns a service probing the named rpc port of its targets
+// and the endpoints listing every target.
+func newStatusTestObjects(
+	port int,
+	targets []string,
+	annotations map[string]string,
+) (*corev1.Service, *corev1.Endpoints) {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-service",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"endpoint-controller/enable":   "true",
+				"endpoint-controller/targets":  "",
+				"endpoint-controller/rpc-port": "rpc",
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "rpc",
+					Port:       int32(port),
+					TargetPort: intstr.FromInt(port),
+				},
+			},
+		},
+	}
+	for k, v := range annotations {
+		service.Annotations[k] = v
+	}
+
+	endpoint := &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-service",
+			Namespace: "default",
+		},
+		Subsets: []corev1.EndpointSubset{
+			{
+				Ports: []corev1.EndpointPort{
+					{
+						Name: "rpc",
+						Port: int32(port),
+					},
+				},
+			},
+		},
+	}
+	for i, target := range targets {
+		if i > 0 {
+			service.Annotations["endpoint-controller/targets"] += ","
+		}
+		service.Annotations["endpoint-controller/targets"] += target
+		endpoint.Subsets[0].Addresses = append(endpoint.Subsets[0].Addresses,
+			corev1.EndpointAddress{IP: target})
+	}
+
+	return service, endpoint
+}
+
+func TestNamedRPCPort(t *testing.T) {
+	ts1 := newStatusServer(t, "127.0.0.1", 0, "1000")
+	defer ts1.Close()
+	port := ts1.Listener.Addr().(*net.TCPAddr).Port
+
+	ts2 := newStatusServer(t, "127.0.0.2", port, "900")
+	defer ts2.Close()
+
+	service, endpoint := newStatusTestObjects(port, []string{"127.0.0.1", "127.0.0.2"}, nil)
+
+	// create a fake clientset
+	clientset := fake.NewSimpleClientset(service, endpoint)
+	recorder := record.NewFakeRecorder(10)
+
+	// create a new controller
+	c := controller.Controller{
+		Clientset: clientset,
+		Resync:    time.Duration(1) * time.Hour,
+		BlockMiss: 6,
+		Recorder:  recorder,
+	}
+
+	// start the controller
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	event := waitForEvent(t, recorder, "Warning TargetRemoved")
+	assert.Equal(t, "Warning TargetRemoved Removed target 127.0.0.2: block height 900 is 100 blocks behind 1000", event)
+}
+
+// authChecker only reports targets healthy when the probes carry the credentials of the rpc secret.
+type authChecker struct{}
+
+func (authChecker) Check(_ context.Context, target string, config blockchain.Config) blockchain.TargetHealth {
+	if target != "1.1.1.1" ||
+		config.RPC.Header.Get("Authorization") != "Bearer secret-token" ||
+		config.RPC.TLS == nil || config.RPC.TLS.ServerName != "node.example.com" ||
+		config.RPC.Host != "rpc.example.com" {
+		return blockchain.TargetHealth{Target: target, Reason: "unauthorized"}
+	}
+	return blockchain.TargetHealth{Target: target, Healthy: true}
+}
+
+func TestRPCSecret(t *testing.T) {
+	service, endpoint := newStatusTestObjects(26657, []string{"1.1.1.1", "2.2.2.2"}, map[string]string{
+		"endpoint-controller/checker":         "auth",
+		"endpoint-controller/rpc-scheme":      "https",
+		"endpoint-controller/rpc-host":        "rpc.example.com",
+		"endpoint-controller/rpc-server-name": "node.example.com",
+		"endpoint-controller/rpc-secret":      "rpc-credentials",
+	})
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "rpc-credentials",
+			Namespace: "default",
+		},
+		Data: map[string][]byte{
+			"token": []byte("secret-token\n"),
+		},
+	}
+
+	// create a fake clientset
+	clientset := fake.NewSimpleClientset(service, endpoint, secret)
+	recorder := record.NewFakeRecorder(10)
+
+	checkers := blockchain.NewRegistry()
+	checkers.Register("auth", authChecker{})
+
+	// create a new controller
+	c := controller.Controller{
+		Clientset: clientset,
+		Resync:    time.Duration(1) * time.Hour,
+		Recorder:  recorder,
+		Checkers:  checkers,
+	}
+
+	// start the controller
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	// 1.1.1.1 stays healthy with the credentials, so only 2.2.2.2 is removed
+	event := waitForEvent(t, recorder, "Warning")
+	assert.Equal(t, "Warning TargetRemoved Removed target 2.2.2.2: unauthorized", event)
+}
+
+func TestMissingRPCSecret(t *testing.T) {
+	service, endpoint := newStatusTestObjects(26657, []string{"1.1.1.1"}, map[string]string{
+		"endpoint-controller/rpc-secret": "rpc-credentials",
+	})
+
+	// create a fake clientset
+	clientset := fake.NewSimpleClientset(service, endpoint)
+	recorder := record.NewFakeRecorder(10)
+
+	// create a new controller
+	c := controller.Controller{
+		Clientset: clientset,
+		Resync:    time.Duration(1) * time.Hour,
+		Recorder:  recorder,
+	}
+
+	// start the controller
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	event := waitForEvent(t, recorder, "Warning InvalidAnnotation")
+	assert.Contains(t, event, "missing secret \"rpc-credentials\"")
+}
+
+// nodeIDChecker only reports targets healthy when they are pinned to the expected node ID.
+type nodeIDChecker struct{}
+
+func (nodeIDChecker) Check(_ context.Context, target string, config blockchain.Config) blockchain.TargetHealth {
+	if config.NodeIDs[target] != "5c2a752c9b1952dbed075c56c600c3a79b58c395" {
+		return blockchain.TargetHealth{Target: target, Reason: "node id is not pinned"}
+	}
+	return blockchain.TargetHealth{Target: target, Healthy: true}
+}
+
+func TestNodeIDTargets(t *testing.T) {
+	service, _ := newStatusTestObjects(26657, nil, map[string]string{
+		"endpoint-controller/checker": "nodeid",
+		"endpoint-controller/targets": "5C2A752C9B1952DBED075C56C600C3A79B58C395@1.1.1.1, 2.2.2.2",
+	})
+
+	// create a fake clientset
+	clientset := fake.NewSimpleClientset(service)
+	recorder := record.NewFakeRecorder(10)
+
+	checkers := blockchain.NewRegistry()
+	checkers.Register("nodeid", nodeIDChecker{})
+
+	// create a new controller
+	c := controller.Controller{
+		Clientset: clientset,
+		Resync:    100 * time.Millisecond,
+		Recorder:  recorder,
+		Checkers:  checkers,
+	}
+
+	// start the controller
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	event := waitForEvent(t, recorder, "Warning TargetRemoved")
+	assert.Equal(t, "Warning TargetRemoved Removed target 2.2.2.2: node id is not pinned", event)
+
+	// the endpoints only hold the IPs of the targets
+	//nolint: staticcheck // the wait package we are using does not have PollWithContextTimeout
+	err := wait.PollImmediate(100*time.Millisecond, 5*time.Second, func() (bool, error) {
+		endpoints, err := clientset.CoreV1().Endpoints("default").Get(
+			context.Background(), "test-service", metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		addresses := endpoints.Subsets[0].Addresses
+		return len(addresses) == 1 && addresses[0].IP == "1.1.1.1", nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestInvalidAnnotations(t *testing.T) {
+	tests := []struct {
+		annotation string
+		value      string
+		message    string
+	}{
+		{"endpoint-controller/rpc-scheme", "grpc",
+			`endpoint-controller/rpc-scheme has invalid scheme "grpc", use http or https`},
+		{"endpoint-controller/max-block-age", "10",
+			`endpoint-controller/max-block-age has invalid duration "10"`},
+		{"endpoint-controller/version", ">=four",
+			"endpoint-controller/version has invalid version constraint"},
+		{"endpoint-controller/reference-height", "mean",
+			`endpoint-controller/reference-height has invalid strategy "mean"`},
+		{"endpoint-controller/reference-rpc", "https://rpc.example.com/status, rpc.example.com:26657",
+			`endpoint-controller/reference-rpc has invalid URL "rpc.example.com:26657"`},
+		{"endpoint-controller/min-healthy", "150%",
+			`endpoint-controller/min-healthy has invalid percentage "150%"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.annotation, func(t *testing.T) {
+			service, endpoint := newStatusTestObjects(26657, []string{"1.1.1.1"}, map[string]string{
+				test.annotation: test.value,
+			})
+
+			// create a new controller
+			recorder := record.NewFakeRecorder(10)
+			c := controller.Controller{
+				Clientset: fake.NewSimpleClientset(service, endpoint),
+				Resync:    time.Duration(1) * time.Hour,
+				Recorder:  recorder,
+			}
+
+			// start the controller
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go c.Run(ctx)
+
+			event := waitForEvent(t, recorder, "Warning InvalidAnnotation")
+			assert.Contains(t, event, test.message)
+		})
+	}
+}
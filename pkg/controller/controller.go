@@ -2,24 +2,81 @@ package controller
 
 import (
 	"context"
+	stderrors "errors"
 	"fmt"
 	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 
 	"github.com/archway-network/endpoint-controller/pkg/blockchain"
+	"github.com/archway-network/endpoint-controller/pkg/metrics"
 )
 
 const (
-	EndpointControllerEnable  = "endpoint-controller/enable"
-	EndpointControllerTargets = "endpoint-controller/targets"
+	EndpointControllerEnable           = "endpoint-controller/enable"
+	EndpointControllerTargets          = "endpoint-controller/targets"
+	EndpointControllerEndpointMode     = "endpoint-controller/endpoint-mode"
+	EndpointControllerChecker          = "endpoint-controller/checker"
+	EndpointControllerRPCScheme        = "endpoint-controller/rpc-scheme"
+	EndpointControllerRPCPort          = "endpoint-controller/rpc-port"
+	EndpointControllerRPCPath          = "endpoint-controller/rpc-path"
+	EndpointControllerRPCHost          = "endpoint-controller/rpc-host"
+	EndpointControllerRPCSNI           = "endpoint-controller/rpc-server-name"
+	EndpointControllerRPCSecret        = "endpoint-controller/rpc-secret"
+	EndpointControllerMaxBlockAge      = "endpoint-controller/max-block-age"
+	EndpointControllerStallChecks      = "endpoint-controller/stall-checks"
+	EndpointControllerMinBlockRate     = "endpoint-controller/min-block-rate"
+	EndpointControllerChainID          = "endpoint-controller/chain-id"
+	EndpointControllerVersion          = "endpoint-controller/version"
+	EndpointControllerVersionSource    = "endpoint-controller/version-source"
+	EndpointControllerAPIPort          = "endpoint-controller/api-port"
+	EndpointControllerMinPeers         = "endpoint-controller/min-peers"
+	EndpointControllerMaxEarliest      = "endpoint-controller/max-earliest-height"
+	EndpointControllerRejectValidators = "endpoint-controller/reject-validators"
+	EndpointControllerForkCheck        = "endpoint-controller/fork-check"
+	EndpointControllerReferenceHeight  = "endpoint-controller/reference-height"
+	EndpointControllerReferenceQuorum  = "endpoint-controller/reference-quorum"
+	EndpointControllerMaxBlocksAhead   = "endpoint-controller/max-blocks-ahead"
+	EndpointControllerReferenceRPC     = "endpoint-controller/reference-rpc"
+	EndpointControllerReferenceRPCMode = "endpoint-controller/reference-rpc-mode"
+	EndpointControllerCheckTimeout     = "endpoint-controller/check-timeout"
+	EndpointControllerRise             = "endpoint-controller/rise"
+	EndpointControllerFall             = "endpoint-controller/fall"
+	EndpointControllerMinHealthy       = "endpoint-controller/min-healthy"
+	EndpointControllerMinHealthyMode   = "endpoint-controller/min-healthy-mode"
+)
+
+// endpoint modes select which objects the controller writes for a service.
+const (
+	EndpointModeEndpoints      = "endpoints"
+	EndpointModeEndpointSlices = "endpointslices"
+	EndpointModeBoth           = "both"
+)
+
+// min healthy modes select what the endpoints hold when less targets than the minimum are healthy.
+const (
+	// MinHealthyModeKeep keeps the current endpoints.
+	MinHealthyModeKeep = "keep"
+	// MinHealthyModeAll routes to every target that was not rejected, also known as panic mode.
+	MinHealthyModeAll = "all"
 )
 
 // Controller defines the endpoint controller.
@@ -27,24 +84,539 @@ type Controller struct {
 	Clientset kubernetes.Interface
 	Resync    time.Duration
 	BlockMiss int
+	Workers   int
+
+	// EndpointMode is the default endpoint mode, it can be overridden
+	// per service with the endpoint-controller/endpoint-mode annotation.
+	EndpointMode string
+
+	// LeaderElection enables Lease based leader election when set,
+	// only the leader reconciles the endpoints.
+	LeaderElection *LeaderElectionConfig
+
+	// Metrics records the controller metrics, metrics are disabled when nil.
+	Metrics *metrics.Metrics
+
+	// Recorder posts events on the services, events are disabled when nil.
+	Recorder record.EventRecorder
+
+	// Checkers holds the health checkers selectable with the
+	// endpoint-controller/checker annotation, defaults to the built in checkers.
+	Checkers *blockchain.Registry
+
+	// RPC holds the default block height probe settings, they are
+	// overridden per service by the endpoint-controller/rpc-* annotations.
+	RPC blockchain.RPCConfig
+
+	// MaxBlockAge is the default max age of the latest block of a target, 0 disables
+	// the check, it is overridden per service by the endpoint-controller/max-block-age annotation.
+	MaxBlockAge time.Duration
+
+	// StallChecks and MinBlockRate are the default height history rules, 0 disables them,
+	// they are overridden per service by the endpoint-controller/stall-checks and
+	// endpoint-controller/min-block-rate annotations.
+	StallChecks  int
+	MinBlockRate float64
+
+	// RejectValidators refuses to route to targets with voting power by default,
+	// it is overridden per service by the endpoint-controller/reject-validators annotation.
+	RejectValidators bool
+
+	// ForkCheck compares the block hashes of the targets by default, it is
+	// overridden per service by the endpoint-controller/fork-check annotation.
+	ForkCheck bool
+
+	// ReferenceHeight, ReferenceQuorum and MaxBlocksAhead select how the reference height
+	// of the block miss rule is computed by default, they are overridden per service by the
+	// endpoint-controller/reference-height, reference-quorum and max-blocks-ahead annotations.
+	ReferenceHeight string
+	ReferenceQuorum int
+	MaxBlocksAhead  int
+
+	// Concurrency is the number of targets checked at the same time across the services,
+	// 0 does not limit the checks.
+	Concurrency int
+
+	// CheckTimeout is the default deadline of the target checks of a service, 0 disables it,
+	// it is overridden per service by the endpoint-controller/check-timeout annotation.
+	CheckTimeout time.Duration
+
+	// Rise and Fall are the default number of consecutive checks a target must pass
+	// to be restored and fail to be removed, they are overridden per service by the
+	// endpoint-controller/rise and endpoint-controller/fall annotations.
+	Rise int
+	Fall int
+
+	// MinHealthy and MinHealthyMode are the default minimum of healthy targets and what
+	// the endpoints hold below it, they are overridden per service by the
+	// endpoint-controller/min-healthy and endpoint-controller/min-healthy-mode annotations.
+	MinHealthy     MinHealthy
+	MinHealthyMode string
+
+	// ShutdownGracePeriod is how long the reconciles in flight when the controller
+	// is stopped can run before they are aborted.
+	ShutdownGracePeriod time.Duration
+
+	limiter             *blockchain.Limiter
+	queue               workqueue.RateLimitingInterface
+	serviceLister       corelisters.ServiceLister
+	endpointsLister     corelisters.EndpointsLister
+	endpointSliceLister discoverylisters.EndpointSliceLister
+
+	// cycle counts the resyncs, the height history and check counters advance
+	// once per cycle so the services synced again in between are not counted twice
+	cycle atomic.Uint64
+
+	// targetHealth holds the last health check results per service key
+	targetHealth map[string]map[string]bool
+	healthMutex  sync.Mutex
+
+	// heightHistory holds the recent block heights per service key and target
+	heightHistory map[string]map[string][]heightSample
+	historyMutex  sync.Mutex
+
+	// targetCounters holds the consecutive check results per service key and target
+	targetCounters map[string]map[string]targetCounter
+	counterMutex   sync.Mutex
 }
 
-// Run starts the endpoint controller.
-func (c *Controller) Run() {
+// Run starts the endpoint controller and returns once the context is canceled
+// and the reconciles in flight finished or were aborted.
+func (c *Controller) Run(ctx context.Context) {
 	klog.Info("Starting endpoint controller...")
 
+	if c.LeaderElection != nil {
+		c.runWithLeaderElection(ctx)
+		return
+	}
+
+	c.run(ctx)
+}
+
+// run reconciles the endpoints until the context is canceled,
+// the reconciles in flight get the shutdown grace period to finish.
+func (c *Controller) run(ctx context.Context) {
+	if c.Checkers == nil {
+		c.Checkers = blockchain.NewRegistry()
+	}
+	c.limiter = blockchain.NewLimiter(c.Concurrency)
+
+	c.queue = workqueue.NewNamedRateLimitingQueue(
+		workqueue.DefaultControllerRateLimiter(), "endpoint-controller",
+	)
+
+	// set up the informers, the resync of the informers is disabled
+	// since health checks are triggered by our own timer
+	factory := informers.NewSharedInformerFactory(c.Clientset, 0)
+	serviceInformer := factory.Core().V1().Services()
+	endpointsInformer := factory.Core().V1().Endpoints()
+	endpointSliceInformer := factory.Discovery().V1().EndpointSlices()
+	c.serviceLister = serviceInformer.Lister()
+	c.endpointsLister = endpointsInformer.Lister()
+	c.endpointSliceLister = endpointSliceInformer.Lister()
+
+	_, err := serviceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueueService,
+		UpdateFunc: c.updateService,
+		DeleteFunc: c.enqueueService,
+	})
+	if err != nil {
+		klog.Fatal(err)
+	}
+	// endpoints and endpoint slices edited by others are written again
+	_, err = endpointsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: c.updateEndpoints,
+		DeleteFunc: c.enqueueService,
+	})
+	if err != nil {
+		klog.Fatal(err)
+	}
+	_, err = endpointSliceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: c.updateEndpointSlice,
+		DeleteFunc: c.enqueueEndpointSliceService,
+	})
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	if !cache.WaitForCacheSync(ctx.Done(),
+		serviceInformer.Informer().HasSynced,
+		endpointsInformer.Informer().HasSynced,
+		endpointSliceInformer.Informer().HasSynced,
+	) {
+		if ctx.Err() != nil {
+			c.queue.ShutDown()
+			return
+		}
+		klog.Fatal("failed to wait for caches to sync")
+	}
+
+	// the reconciles have their own context so stopping the controller
+	// does not abort the endpoint updates in flight
+	workCtx, abort := context.WithCancel(context.Background())
+	defer abort()
+
+	workers := c.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.runWorker(workCtx)
+		}()
+	}
+
 	// set up the resync timer
 	timer := time.NewTicker(c.Resync)
 	defer timer.Stop()
 	klog.Infof("Synching every %s", c.Resync)
 
-	c.resyncEndpoints()
-	for range timer.C {
-		klog.Info("Resynching endpoints")
-		c.resyncEndpoints()
+	for {
+		select {
+		case <-ctx.Done():
+			klog.Info("Stopping endpoint controller")
+			c.shutdown(&wg, abort)
+			return
+		case <-timer.C:
+			klog.Info("Resynching endpoints")
+			c.resyncEndpoints(ctx)
+		}
+	}
+}
+
+// shutdown stops the workers once their reconciles in flight finished,
+// the reconciles still running after the shutdown grace period are aborted.
+func (c *Controller) shutdown(workers *sync.WaitGroup, abort context.CancelFunc) {
+	c.queue.ShutDown()
+
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(c.ShutdownGracePeriod)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		klog.Infof("Aborting the reconciles still running after %s", c.ShutdownGracePeriod)
+		abort()
+		<-done
+	}
+}
+
+// enqueueService adds the service or endpoints key to the workqueue.
+// Services and their endpoints share the same namespace/name key.
+func (c *Controller) enqueueService(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+// updateService enqueues the service when its annotations or ports changed.
+func (c *Controller) updateService(oldObj, newObj interface{}) {
+	oldService, ok := oldObj.(*corev1.Service)
+	if !ok {
+		return
+	}
+	newService, ok := newObj.(*corev1.Service)
+	if !ok {
+		return
+	}
+
+	if reflect.DeepEqual(oldService.Annotations, newService.Annotations) &&
+		reflect.DeepEqual(oldService.Spec.Ports, newService.Spec.Ports) {
+		return
+	}
+	c.enqueueService(newObj)
+}
+
+// updateEndpoints enqueues the service of the endpoints when their subsets or labels changed,
+// the services not watched are skipped by syncService.
+func (c *Controller) updateEndpoints(oldObj, newObj interface{}) {
+	oldEndpoints, ok := oldObj.(*corev1.Endpoints)
+	if !ok {
+		return
+	}
+	newEndpoints, ok := newObj.(*corev1.Endpoints)
+	if !ok {
+		return
+	}
+
+	if reflect.DeepEqual(oldEndpoints.Subsets, newEndpoints.Subsets) &&
+		reflect.DeepEqual(oldEndpoints.Labels, newEndpoints.Labels) {
+		return
+	}
+	c.enqueueService(newObj)
+}
+
+// runWorker processes items from the workqueue until it is shut down.
+func (c *Controller) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
 	}
 }
 
+// processNextItem syncs a single key from the workqueue
+// return false when the queue is shut down.
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	// the services still queued are not reconciled once the controller is stopped
+	if c.queue.ShuttingDown() {
+		return false
+	}
+
+	keyString, ok := key.(string)
+	if !ok {
+		c.queue.Forget(key)
+		return true
+	}
+
+	if err := c.syncService(ctx, keyString); err != nil {
+		klog.Errorf("error synching %s: %v", keyString, err)
+		// invalid annotations are reported with an event and synced again
+		// once the service changes or on the next resync
+		var annotationErr *InvalidAnnotationError
+		if !stderrors.As(err, &annotationErr) {
+			c.queue.AddRateLimited(key)
+			return true
+		}
+	}
+
+	c.queue.Forget(key)
+	return true
+}
+
+// syncService reconciles the endpoints of the service with the given key.
+func (c *Controller) syncService(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+
+	service, err := c.serviceLister.Services(namespace).Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			c.Metrics.DeleteService(namespace, name)
+			c.forgetTargetHealth(namespace, name)
+			c.forgetHeightHistory(namespace, name)
+			c.forgetTargetCounters(namespace, name)
+			return nil
+		}
+		return err
+	}
+
+	if service.Annotations[EndpointControllerEnable] != "true" {
+		c.Metrics.DeleteService(namespace, name)
+		c.forgetTargetHealth(namespace, name)
+		c.forgetHeightHistory(namespace, name)
+		c.forgetTargetCounters(namespace, name)
+		return nil
+	}
+
+	start := time.Now()
+	err = c.reconcileService(ctx, *service)
+	c.Metrics.ObserveReconcile(namespace, name, time.Since(start), err)
+
+	var annotationErr *InvalidAnnotationError
+	if stderrors.As(err, &annotationErr) {
+		c.event(*service, corev1.EventTypeWarning, EventReasonInvalidAnnotation, "%s", annotationErr.Error())
+	}
+	return err
+}
+
+// reconcileService writes the endpoints and endpoint slices of the service.
+func (c *Controller) reconcileService(ctx context.Context, service corev1.Service) error {
+	mode, err := c.endpointMode(service)
+	if err != nil {
+		return err
+	}
+
+	// the health check is shared between the endpoints and endpoint slices
+	healthCheck := c.newHealthCheck(ctx, service)
+
+	if mode == EndpointModeEndpointSlices {
+		err = c.deleteEndpoints(ctx, service)
+	} else {
+		err = c.findEndpoints(ctx, *service.DeepCopy(), mode, healthCheck)
+	}
+	if err != nil {
+		return err
+	}
+
+	if mode == EndpointModeEndpoints {
+		return c.deleteEndpointSlices(ctx, service)
+	}
+	return c.findEndpointSlices(ctx, *service.DeepCopy(), healthCheck)
+}
+
+// apiError records a failed Kubernetes API call and returns the error.
+func (c *Controller) apiError(resource, verb string, err error) error {
+	if err != nil {
+		c.Metrics.APIError(resource, verb)
+	}
+	return err
+}
+
+// endpointMode returns the endpoint mode of the service.
+func (c *Controller) endpointMode(service corev1.Service) (string, error) {
+	mode := c.EndpointMode
+	if value, ok := service.Annotations[EndpointControllerEndpointMode]; ok {
+		mode = strings.TrimSpace(value)
+	}
+
+	switch mode {
+	case "":
+		return EndpointModeEndpoints, nil
+	case EndpointModeEndpoints, EndpointModeEndpointSlices, EndpointModeBoth:
+		return mode, nil
+	default:
+		return "", &InvalidAnnotationError{
+			Service:    service.Name,
+			Annotation: EndpointControllerEndpointMode,
+			Message:    fmt.Sprintf("has invalid endpoint mode %q", mode),
+		}
+	}
+}
+
+// healthCheckFunc returns the healthy targets of a service,
+// no targets keeps the current endpoints.
+type healthCheckFunc func() ([]string, error)
+
+// newHealthCheck returns a healthCheckFunc that checks the service targets
+// on the first call and returns the cached result afterwards.
+func (c *Controller) newHealthCheck(ctx context.Context, service corev1.Service) healthCheckFunc {
+	var healthyTargets []string
+	var err error
+	var done bool
+
+	return func() ([]string, error) {
+		if !done {
+			healthyTargets, err = c.checkTargets(ctx, service)
+			done = true
+		}
+		return healthyTargets, err
+	}
+}
+
+// checkTargets checks the health of the service targets
+// return no targets to keep the current endpoints when none is healthy, the outcome
+// is reported with events and metrics and checked again on the next resync
+// return error if the targets could not be checked.
+func (c *Controller) checkTargets(ctx context.Context, service corev1.Service) ([]string, error) {
+	checker, err := c.checker(service)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := c.healthCheckConfig(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := c.historyConfig(service)
+	if err != nil {
+		return nil, err
+	}
+
+	hysteresis, err := c.hysteresisConfig(service)
+	if err != nil {
+		return nil, err
+	}
+
+	minHealthy, err := c.minHealthyConfig(service)
+	if err != nil {
+		return nil, err
+	}
+
+	cycle := c.cycle.Load()
+	results := blockchain.HealthCheck(ctx, checker, serviceTargets(service), config)
+	c.checkHeightHistory(service, results, history, cycle)
+	c.applyHysteresis(service, results, hysteresis, cycle)
+	healthyTargets := blockchain.HealthyTargets(results)
+	c.recordHealthMetrics(service, results, healthyTargets)
+	// the health transitions are recorded while the floor holds too,
+	// so lifting it does not post stale events
+	c.recordTargetEvents(service, results)
+
+	if floor := minHealthy.minHealthy.Floor(len(results)); len(healthyTargets) < floor {
+		return c.belowMinHealthy(service, results, healthyTargets, floor, minHealthy.mode), nil
+	}
+	c.Metrics.SetBelowMinHealthy(service.Namespace, service.Name, false)
+
+	return healthyTargets, nil
+}
+
+// recordHealthMetrics records the health check results of the service targets.
+func (c *Controller) recordHealthMetrics(
+	service corev1.Service,
+	results []blockchain.TargetHealth,
+	healthyTargets []string,
+) {
+	c.Metrics.SetTargets(service.Namespace, service.Name, len(results), len(healthyTargets))
+	c.Metrics.ResetTargets(service.Namespace, service.Name)
+	for _, result := range results {
+		c.Metrics.SetTargetHealth(service.Namespace, service.Name, result.Target, result.Healthy)
+		if result.BlockHeight > 0 {
+			c.Metrics.SetBlockHeight(service.Namespace, service.Name,
+				result.Target, result.BlockHeight, result.BlockLag)
+		}
+		if result.BlockRate >= 0 {
+			c.Metrics.SetBlockRate(service.Namespace, service.Name, result.Target, result.BlockRate)
+		}
+		if !result.BlockTime.IsZero() {
+			c.Metrics.SetBlockAge(service.Namespace, service.Name,
+				result.Target, time.Since(result.BlockTime))
+		}
+		for _, port := range result.Ports {
+			c.Metrics.ObservePortCheck(service.Namespace, service.Name,
+				result.Target, port.Port, port.Latency, port.Err)
+		}
+	}
+}
+
+// serviceTargets splits the targets annotation, removes spaces and node IDs.
+func serviceTargets(service corev1.Service) []string {
+	ips := strings.Split(service.Annotations[EndpointControllerTargets], ",")
+	for ip := range ips {
+		_, ips[ip] = splitTarget(ips[ip])
+	}
+	return ips
+}
+
+// serviceNodeIDs returns the node IDs of the targets written as nodeid@ip by IP.
+func serviceNodeIDs(service corev1.Service) map[string]string {
+	nodeIDs := map[string]string{}
+	for _, target := range strings.Split(service.Annotations[EndpointControllerTargets], ",") {
+		if nodeID, ip := splitTarget(target); nodeID != "" {
+			nodeIDs[ip] = nodeID
+		}
+	}
+	return nodeIDs
+}
+
+// splitTarget splits a target written as ip or nodeid@ip, like CometBFT peers.
+func splitTarget(target string) (string, string) {
+	target = strings.TrimSpace(target)
+	if i := strings.LastIndex(target, "@"); i >= 0 {
+		return strings.ToLower(strings.TrimSpace(target[:i])), strings.TrimSpace(target[i+1:])
+	}
+	return "", target
+}
+
 // checkPortSync checks ports are matching between service and endpoint.
 func (c *Controller) checkPortSync(service corev1.Service, endpoints corev1.Endpoints) bool {
 	serviceEndpointPortObjects := createEndpointPortObject(service)
@@ -78,38 +650,58 @@ func createEndpointAddressObject(service corev1.Service) ([]corev1.EndpointAddre
 	var addresses []corev1.EndpointAddress
 	serviceEndpointsAddress := service.Annotations[EndpointControllerTargets]
 	if serviceEndpointsAddress == "" {
-		return addresses, fmt.Errorf(
-			"%s : annotation endpoint-controller/targets is empty",
-			service.Name,
-		)
+		return addresses, &InvalidAnnotationError{
+			Service:    service.Name,
+			Annotation: EndpointControllerTargets,
+			Message:    "is empty",
+		}
 	}
 
-	for _, address := range strings.Split(serviceEndpointsAddress, ",") {
+	for _, address := range serviceTargets(service) {
 		addresses = append(addresses, corev1.EndpointAddress{
-			IP: strings.TrimSpace(address),
+			IP: address,
 		})
 	}
 	return addresses, nil
 }
 
 // patchEndpoints patches the endpoint with correct set of data.
-func (c *Controller) patchEndpoints(endpoints corev1.Endpoints) error {
+func (c *Controller) patchEndpoints(ctx context.Context, endpoints corev1.Endpoints) error {
 	_, err := c.Clientset.CoreV1().Endpoints(endpoints.Namespace).Update(
-		context.Background(), &endpoints, v1.UpdateOptions{},
+		ctx, &endpoints, v1.UpdateOptions{},
 	)
 	if err != nil {
-		return err
+		return c.apiError("endpoints", "update", err)
 	}
 
 	klog.Infof("Updated endpoint %s", endpoints.Name)
 	return nil
 }
 
-// createEndpoints creates an endpoint for the given service.
-func (c *Controller) createEndpoints(service corev1.Service) error {
-	var err error
-	var subset corev1.EndpointSubset
+// createEndpoints creates an endpoint for the given service with its healthy targets,
+// every target is listed when none is healthy.
+func (c *Controller) createEndpoints(
+	ctx context.Context,
+	service corev1.Service,
+	mode string,
+	healthCheck healthCheckFunc,
+) error {
+	addresses, err := createEndpointAddressObject(service)
+	if err != nil {
+		return err
+	}
+	healthyTargets, err := healthCheck()
+	if err != nil {
+		return err
+	}
+	if len(healthyTargets) > 0 {
+		addresses = make([]corev1.EndpointAddress, 0, len(healthyTargets))
+		for _, target := range healthyTargets {
+			addresses = append(addresses, corev1.EndpointAddress{IP: target})
+		}
+	}
 
+	var subset corev1.EndpointSubset
 	retryErr := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
 		endpoints := &corev1.Endpoints{
 			ObjectMeta: v1.ObjectMeta{
@@ -119,21 +711,19 @@ func (c *Controller) createEndpoints(service corev1.Service) error {
 			},
 			Subsets: []corev1.EndpointSubset{},
 		}
+		setSkipMirrorLabel(endpoints, mode)
 
 		// create subset objects.
 		subset.Ports = createEndpointPortObject(service)
-		subset.Addresses, err = createEndpointAddressObject(service)
-		if err != nil {
-			return err
-		}
+		subset.Addresses = addresses
 		endpoints.Subsets = append(endpoints.Subsets, subset)
 
 		// create the endpoints.
 		_, err = c.Clientset.CoreV1().Endpoints(service.Namespace).Create(
-			context.Background(), endpoints, v1.CreateOptions{},
+			ctx, endpoints, v1.CreateOptions{},
 		)
 		if err != nil {
-			return err
+			return c.apiError("endpoints", "create", err)
 		}
 
 		klog.Infof("Created endpoint for service %s\n", service.Name)
@@ -144,75 +734,136 @@ func (c *Controller) createEndpoints(service corev1.Service) error {
 	return retryErr
 }
 
-// resyncEndpoints updates all endpoints for the watched services.
-func (c *Controller) resyncEndpoints() {
-	services, err := c.Clientset.CoreV1().Services("").List(context.Background(), v1.ListOptions{})
+// resyncEndpoints starts a new cycle and enqueues all the watched services
+// so that their endpoints targets health is checked again
+// no service is enqueued once the context is canceled.
+func (c *Controller) resyncEndpoints(ctx context.Context) {
+	c.cycle.Add(1)
+
+	services, err := c.serviceLister.List(labels.Everything())
 	if err != nil {
 		klog.Error(err)
+		return
 	}
 
-	// check all services that have operator enabled.
-	for _, service := range services.Items {
+	// enqueue all services that have operator enabled.
+	for _, service := range services {
+		if ctx.Err() != nil {
+			return
+		}
 		if service.Annotations[EndpointControllerEnable] == "true" {
-			if err = c.findEndpoints(service); err != nil {
-				klog.Error(err)
-			}
+			c.enqueueService(service)
 		}
 	}
-
-	klog.Info("Finished synching endpoints")
 }
 
 // findEndpoints
 // finds endpoints and checks if it matches with the service
 // if it matches, checks the endpoints targets health
-// if not found, creates the endpoints
+// if not found, creates the endpoints with the healthy targets
 // return error if something breaks.
-func (c *Controller) findEndpoints(service corev1.Service) error {
-	endpoints, err := c.Clientset.CoreV1().
-		Endpoints(service.Namespace).
-		Get(context.Background(), service.Name, v1.GetOptions{})
+func (c *Controller) findEndpoints(
+	ctx context.Context,
+	service corev1.Service,
+	mode string,
+	healthCheck healthCheckFunc,
+) error {
+	endpoints, err := c.endpointsLister.Endpoints(service.Namespace).Get(service.Name)
 	if err != nil {
 		if errors.IsNotFound(err) {
-			if err = c.createEndpoints(service); err != nil {
-				return err
-			}
+			return c.createEndpoints(ctx, service, mode, healthCheck)
 		}
 		return err
 	}
 
-	return c.checkEndpoints(service, *endpoints)
+	return c.checkEndpoints(ctx, service, *endpoints.DeepCopy(), mode, healthCheck)
 }
 
 // check if endpoint exists and the configuration is up to date
 // return error if nothing goes wrong.
-func (c *Controller) checkEndpoints(service corev1.Service, endpoint corev1.Endpoints) error {
+func (c *Controller) checkEndpoints(
+	ctx context.Context,
+	service corev1.Service,
+	endpoint corev1.Endpoints,
+	mode string,
+	healthCheck healthCheckFunc,
+) error {
+	var patchNeeded bool
 	if !c.checkPortSync(service, endpoint) {
 		endpoint.Subsets[0].Ports = createEndpointPortObject(service)
-		if err := c.patchEndpoints(endpoint); err != nil {
-			return err
+		patchNeeded = true
+	}
+	if setSkipMirrorLabel(&endpoint, mode) {
+		patchNeeded = true
+	}
+
+	// the current targets are kept when none is healthy
+	healthyTargets, err := healthCheck()
+	if err != nil || len(healthyTargets) == 0 {
+		if patchNeeded {
+			if patchErr := c.patchEndpoints(ctx, endpoint); patchErr != nil {
+				return patchErr
+			}
 		}
+		return err
 	}
 
-	// split the annotation and remove spaces
-	ips := strings.Split(service.Annotations[EndpointControllerTargets], ",")
-	for ip := range ips {
-		ips[ip] = strings.TrimSpace(ips[ip])
+	if EndpointUpdateNeeded(healthyTargets, endpoint.Subsets[0].Addresses) {
+		return c.UpdateEndpointTargets(ctx, endpoint, healthyTargets)
 	}
-	healthyTargets := blockchain.HealthCheck(
-		ips,
-		endpoint.Subsets[0].Ports,
-		c.BlockMiss,
-	)
 
-	if len(healthyTargets) == 0 {
-		return fmt.Errorf("no healthy targets")
+	if patchNeeded {
+		return c.patchEndpoints(ctx, endpoint)
 	}
 
-	if EndpointUpdateNeeded(healthyTargets, endpoint.Subsets[0].Addresses) {
-		return c.UpdateEndpointTargets(endpoint, healthyTargets)
+	return nil
+}
+
+// setSkipMirrorLabel stops the endpoint slice mirroring of endpoints
+// when the controller writes the endpoint slices itself
+// return true if the labels were changed.
+func setSkipMirrorLabel(endpoints *corev1.Endpoints, mode string) bool {
+	_, skipMirror := endpoints.Labels[discoveryv1.LabelSkipMirror]
+	if mode == EndpointModeBoth {
+		if skipMirror {
+			return false
+		}
+		if endpoints.Labels == nil {
+			endpoints.Labels = map[string]string{}
+		}
+		endpoints.Labels[discoveryv1.LabelSkipMirror] = "true"
+		return true
+	}
+
+	if !skipMirror {
+		return false
+	}
+	delete(endpoints.Labels, discoveryv1.LabelSkipMirror)
+	return true
+}
+
+// deleteEndpoints deletes the endpoints created by the controller for the service.
+func (c *Controller) deleteEndpoints(ctx context.Context, service corev1.Service) error {
+	endpoints, err := c.endpointsLister.Endpoints(service.Namespace).Get(service.Name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if endpoints.Annotations[EndpointControllerEnable] != "true" {
+		return nil
+	}
+
+	err = c.Clientset.CoreV1().Endpoints(service.Namespace).Delete(
+		ctx, service.Name, v1.DeleteOptions{},
+	)
+	if err != nil && !errors.IsNotFound(err) {
+		return c.apiError("endpoints", "delete", err)
 	}
 
+	klog.Infof("Deleted endpoint %s", service.Name)
 	return nil
 }
 
@@ -233,7 +884,7 @@ func EndpointUpdateNeeded(
 }
 
 // Update endpoint targets.
-func (c *Controller) UpdateEndpointTargets(endpoints corev1.Endpoints, ips []string) error {
+func (c *Controller) UpdateEndpointTargets(ctx context.Context, endpoints corev1.Endpoints, ips []string) error {
 	endpointAddressList := []corev1.EndpointAddress{}
 	for _, ip := range ips {
 		endpointAddressList = append(endpointAddressList, corev1.EndpointAddress{
@@ -243,5 +894,5 @@ func (c *Controller) UpdateEndpointTargets(endpoints corev1.Endpoints, ips []str
 	}
 
 	klog.Infof("resynching endpoints (%s) targets (%s)", endpoints.Name, ips)
-	return c.patchEndpoints(endpoints)
+	return c.patchEndpoints(ctx, endpoints)
 }
@@ -106,7 +106,9 @@ func TestController(t *testing.T) {
 	}
 
 	// start the controller
-	go c.Run()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
 
 	//nolint: staticcheck // the wait package we are using does not have PollWithContextTimeout
 	err = wait.PollImmediate(1*time.Second, 6*time.Second, func() (bool, error) {
@@ -181,7 +183,7 @@ func TestUpdateEndpoint(t *testing.T) {
 
 	assert.NoError(t, err)
 	ips := []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}
-	err = c.UpdateEndpointTargets(*endpoint, ips)
+	err = c.UpdateEndpointTargets(context.Background(), *endpoint, ips)
 	assert.NoError(t, err)
 
 	expectedEndpoint := &corev1.Endpoints{
@@ -317,7 +319,7 @@ func TestRemoveEndpointTarget(t *testing.T) {
 	assert.NoError(t, err)
 
 	ips := []string{"1.1.1.1"}
-	err = c.UpdateEndpointTargets(*endpoint, ips)
+	err = c.UpdateEndpointTargets(context.Background(), *endpoint, ips)
 	assert.NoError(t, err)
 
 	// check that the endpoint is correct
@@ -357,3 +359,93 @@ func TestUpdateNeeded(t *testing.T) {
 		t.Errorf("EndpointUpdateNeeded returned false when it should have returned true")
 	}
 }
+
+func TestControllerReconcilesOnServiceEvents(t *testing.T) {
+	// create a fake clientset
+	clientset := fake.NewSimpleClientset()
+
+	// create a new controller with a resync period that never fires during the test
+	c := controller.Controller{
+		Clientset: clientset,
+		Resync:    time.Duration(1) * time.Hour,
+	}
+
+	// start the controller before the service exists
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	// create a test service that is not yet enabled
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-service-events",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"endpoint-controller/targets": "1.1.1.1",
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "test-port",
+					Port:       8080,
+					TargetPort: intstr.FromInt(8080),
+				},
+			},
+		},
+	}
+	_, err := clientset.CoreV1().
+		Services(service.Namespace).
+		Create(context.Background(), service, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	// enable the controller through the annotation
+	service.Annotations["endpoint-controller/enable"] = "true"
+	_, err = clientset.CoreV1().
+		Services(service.Namespace).
+		Update(context.Background(), service, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	//nolint: staticcheck // the wait package we are using does not have PollWithContextTimeout
+	err = wait.PollImmediate(100*time.Millisecond, 5*time.Second, func() (bool, error) {
+		_, err = clientset.CoreV1().Endpoints(
+			service.Namespace).Get(context.Background(),
+			service.Name, metav1.GetOptions{})
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestControllerReconcilesEditedEndpoints(t *testing.T) {
+	checker := newScriptChecker(map[string][]bool{
+		"1.1.1.1": {true},
+		"2.2.2.2": {false},
+	})
+	c := &controller.Controller{Resync: time.Hour}
+	recorder := runCheckerController(t, checker, []string{"1.1.1.1", "2.2.2.2"}, c)
+
+	event := waitForEvent(t, recorder, "Warning TargetRemoved")
+	assert.Equal(t, "Warning TargetRemoved Removed target 2.2.2.2: target is down", event)
+
+	// someone else adds the unhealthy target back before the next resync
+	endpoint, err := c.Clientset.CoreV1().Endpoints("default").Get(
+		context.Background(), "test-service", metav1.GetOptions{})
+	assert.NoError(t, err)
+	endpoint.Subsets[0].Addresses = []corev1.EndpointAddress{{IP: "1.1.1.1"}, {IP: "2.2.2.2"}}
+	_, err = c.Clientset.CoreV1().Endpoints("default").Update(
+		context.Background(), endpoint, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	//nolint: staticcheck // the wait package we are using does not have PollWithContextTimeout
+	err = wait.PollImmediate(50*time.Millisecond, 5*time.Second, func() (bool, error) {
+		targets := endpointTargets(t, c)
+		return len(targets) == 1 && targets[0] == "1.1.1.1", nil
+	})
+	assert.NoError(t, err)
+}
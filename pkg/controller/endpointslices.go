@@ -0,0 +1,294 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// EndpointSliceManagedBy is the managed-by label of the endpoint slices written by the controller.
+	EndpointSliceManagedBy = "endpoint-controller.archway.network"
+
+	maxEndpointsPerSlice = 100
+)
+
+// enqueueEndpointSliceService adds the service of an endpoint slice
+// managed by the controller to the workqueue.
+func (c *Controller) enqueueEndpointSliceService(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	endpointSlice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		return
+	}
+
+	serviceName := endpointSlice.Labels[discoveryv1.LabelServiceName]
+	if serviceName == "" || endpointSlice.Labels[discoveryv1.LabelManagedBy] != EndpointSliceManagedBy {
+		return
+	}
+	c.queue.Add(endpointSlice.Namespace + "/" + serviceName)
+}
+
+// updateEndpointSlice enqueues the service of an endpoint slice written by the controller
+// when its endpoints, ports or labels changed.
+func (c *Controller) updateEndpointSlice(oldObj, newObj interface{}) {
+	oldEndpointSlice, ok := oldObj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		return
+	}
+	newEndpointSlice, ok := newObj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		return
+	}
+
+	if reflect.DeepEqual(oldEndpointSlice.Endpoints, newEndpointSlice.Endpoints) &&
+		reflect.DeepEqual(oldEndpointSlice.Ports, newEndpointSlice.Ports) &&
+		reflect.DeepEqual(oldEndpointSlice.Labels, newEndpointSlice.Labels) {
+		return
+	}
+	// a slice relabeled by others is still synced through its previous labels
+	c.enqueueEndpointSliceService(oldObj)
+	c.enqueueEndpointSliceService(newObj)
+}
+
+// endpointSliceSelector selects the endpoint slices written by the controller for the service.
+func endpointSliceSelector(service corev1.Service) labels.Selector {
+	return labels.SelectorFromSet(labels.Set{
+		discoveryv1.LabelServiceName: service.Name,
+		discoveryv1.LabelManagedBy:   EndpointSliceManagedBy,
+	})
+}
+
+// findEndpointSlices
+// finds the endpoint slices of the service
+// checks the targets health and updates or creates the endpoint conditions
+// the endpoint slices created while no target is healthy have every target ready
+// return error if something breaks.
+func (c *Controller) findEndpointSlices(
+	ctx context.Context,
+	service corev1.Service,
+	healthCheck healthCheckFunc,
+) error {
+	endpointSlices, err := c.endpointSliceLister.
+		EndpointSlices(service.Namespace).
+		List(endpointSliceSelector(service))
+	if err != nil {
+		return err
+	}
+
+	healthyTargets, err := healthCheck()
+	if err != nil {
+		return err
+	}
+	// the current conditions are kept when no target is healthy
+	if len(healthyTargets) == 0 {
+		if len(endpointSlices) > 0 {
+			return nil
+		}
+		healthyTargets = serviceTargets(service)
+	}
+
+	return c.UpdateEndpointSliceTargets(ctx, service, endpointSlices, healthyTargets)
+}
+
+// deleteEndpointSlices deletes the endpoint slices written by the controller for the service.
+func (c *Controller) deleteEndpointSlices(ctx context.Context, service corev1.Service) error {
+	endpointSlices, err := c.endpointSliceLister.
+		EndpointSlices(service.Namespace).
+		List(endpointSliceSelector(service))
+	if err != nil {
+		return err
+	}
+
+	for _, endpointSlice := range endpointSlices {
+		if err = c.deleteEndpointSlice(ctx, endpointSlice); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteEndpointSlice deletes a single endpoint slice.
+func (c *Controller) deleteEndpointSlice(ctx context.Context, endpointSlice *discoveryv1.EndpointSlice) error {
+	err := c.Clientset.DiscoveryV1().EndpointSlices(endpointSlice.Namespace).Delete(
+		ctx, endpointSlice.Name, v1.DeleteOptions{},
+	)
+	if err != nil && !errors.IsNotFound(err) {
+		return c.apiError("endpointslices", "delete", err)
+	}
+
+	klog.Infof("Deleted endpoint slice %s", endpointSlice.Name)
+	return nil
+}
+
+// UpdateEndpointSliceTargets
+// writes the endpoint slices of the service from the existing ones
+// every target is listed, healthy targets are ready and serving
+// endpoint slices that are not needed anymore are deleted.
+func (c *Controller) UpdateEndpointSliceTargets(
+	ctx context.Context,
+	service corev1.Service,
+	existing []*discoveryv1.EndpointSlice,
+	healthyTargets []string,
+) error {
+	endpointSlices, err := createEndpointSliceObjects(service, healthyTargets)
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]*discoveryv1.EndpointSlice, len(existing))
+	for _, endpointSlice := range existing {
+		current[endpointSlice.Name] = endpointSlice
+	}
+
+	client := c.Clientset.DiscoveryV1().EndpointSlices(service.Namespace)
+	for _, endpointSlice := range endpointSlices {
+		old, ok := current[endpointSlice.Name]
+		delete(current, endpointSlice.Name)
+
+		if !ok {
+			if _, err = client.Create(ctx, endpointSlice, v1.CreateOptions{}); err != nil {
+				return c.apiError("endpointslices", "create", err)
+			}
+			klog.Infof("Created endpoint slice %s for service %s", endpointSlice.Name, service.Name)
+			continue
+		}
+
+		if !EndpointSliceUpdateNeeded(endpointSlice, old) {
+			continue
+		}
+		endpointSlice.ResourceVersion = old.ResourceVersion
+		if _, err = client.Update(ctx, endpointSlice, v1.UpdateOptions{}); err != nil {
+			return c.apiError("endpointslices", "update", err)
+		}
+		klog.Infof("resynching endpoint slice (%s) healthy targets (%s)", endpointSlice.Name, healthyTargets)
+	}
+
+	// remove endpoint slices left over from a larger set of targets
+	for _, endpointSlice := range current {
+		if err = c.deleteEndpointSlice(ctx, endpointSlice); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EndpointSliceUpdateNeeded
+// check if the endpoint slice needs to be updated
+// return true if update is needed
+// return false if update is not needed.
+func EndpointSliceUpdateNeeded(desired, current *discoveryv1.EndpointSlice) bool {
+	return desired.AddressType != current.AddressType ||
+		!reflect.DeepEqual(desired.Labels, current.Labels) ||
+		!reflect.DeepEqual(desired.Endpoints, current.Endpoints) ||
+		!reflect.DeepEqual(desired.Ports, current.Ports)
+}
+
+// create endpoint slice objects, split by address type and size.
+func createEndpointSliceObjects(
+	service corev1.Service,
+	healthyTargets []string,
+) ([]*discoveryv1.EndpointSlice, error) {
+	if service.Annotations[EndpointControllerTargets] == "" {
+		return nil, &InvalidAnnotationError{
+			Service:    service.Name,
+			Annotation: EndpointControllerTargets,
+			Message:    "is empty",
+		}
+	}
+
+	healthy := make(map[string]bool, len(healthyTargets))
+	for _, target := range healthyTargets {
+		healthy[target] = true
+	}
+
+	endpoints := map[discoveryv1.AddressType][]discoveryv1.Endpoint{}
+	for _, target := range serviceTargets(service) {
+		ip := net.ParseIP(target)
+		if ip == nil {
+			return nil, &InvalidAnnotationError{
+				Service:    service.Name,
+				Annotation: EndpointControllerTargets,
+				Message:    fmt.Sprintf("target %q is not a valid IP", target),
+			}
+		}
+
+		addressType := discoveryv1.AddressTypeIPv4
+		if ip.To4() == nil {
+			addressType = discoveryv1.AddressTypeIPv6
+		}
+
+		endpoints[addressType] = append(endpoints[addressType], discoveryv1.Endpoint{
+			Addresses: []string{target},
+			Conditions: discoveryv1.EndpointConditions{
+				Ready:       boolPtr(healthy[target]),
+				Serving:     boolPtr(healthy[target]),
+				Terminating: boolPtr(false),
+			},
+		})
+	}
+
+	var endpointSlices []*discoveryv1.EndpointSlice
+	for _, addressType := range []discoveryv1.AddressType{
+		discoveryv1.AddressTypeIPv4,
+		discoveryv1.AddressTypeIPv6,
+	} {
+		for i := 0; i*maxEndpointsPerSlice < len(endpoints[addressType]); i++ {
+			end := (i + 1) * maxEndpointsPerSlice
+			if end > len(endpoints[addressType]) {
+				end = len(endpoints[addressType])
+			}
+
+			endpointSlices = append(endpointSlices, &discoveryv1.EndpointSlice{
+				ObjectMeta: v1.ObjectMeta{
+					Name: fmt.Sprintf("%s-%s-%d",
+						service.Name, strings.ToLower(string(addressType)), i),
+					Namespace: service.Namespace,
+					Labels: map[string]string{
+						discoveryv1.LabelServiceName: service.Name,
+						discoveryv1.LabelManagedBy:   EndpointSliceManagedBy,
+					},
+					OwnerReferences: []v1.OwnerReference{
+						*v1.NewControllerRef(&service, corev1.SchemeGroupVersion.WithKind("Service")),
+					},
+				},
+				AddressType: addressType,
+				Endpoints:   endpoints[addressType][i*maxEndpointsPerSlice : end],
+				Ports:       createEndpointSlicePortObject(service),
+			})
+		}
+	}
+
+	return endpointSlices, nil
+}
+
+// create endpoint slice port object.
+func createEndpointSlicePortObject(service corev1.Service) []discoveryv1.EndpointPort {
+	var ports []discoveryv1.EndpointPort
+	for _, port := range service.Spec.Ports {
+		name := port.Name
+		protocol := port.Protocol
+		number := port.Port
+		ports = append(ports, discoveryv1.EndpointPort{
+			Name: &name, Protocol: &protocol, Port: &number,
+		})
+	}
+	return ports
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
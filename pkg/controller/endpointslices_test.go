@@ -0,0 +1,272 @@
+package controller_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/archway-network/endpoint-controller/pkg/controller"
+)
+
+func newEndpointSliceTestService(mode string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-service",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"endpoint-controller/enable":        "true",
+				"endpoint-controller/targets":       "1.1.1.1,2.2.2.2",
+				"endpoint-controller/endpoint-mode": mode,
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "test-port",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       8080,
+					TargetPort: intstr.FromInt(8080),
+				},
+			},
+		},
+	}
+}
+
+// endpointReady returns the ready condition of every endpoint address.
+func endpointReady(t *testing.T, endpointSlice discoveryv1.EndpointSlice) map[string]bool {
+	ready := map[string]bool{}
+	for _, endpoint := range endpointSlice.Endpoints {
+		ready[endpoint.Addresses[0]] = *endpoint.Conditions.Ready
+		assert.Equal(t, *endpoint.Conditions.Ready, *endpoint.Conditions.Serving)
+	}
+	return ready
+}
+
+func TestControllerEndpointSlices(t *testing.T) {
+	// create a fake clientset
+	clientset := fake.NewSimpleClientset()
+
+	service := newEndpointSliceTestService("endpointslices")
+	_, err := clientset.CoreV1().
+		Services(service.Namespace).
+		Create(context.Background(), service, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	// create a new controller
+	c := controller.Controller{
+		Clientset: clientset,
+		Resync:    time.Duration(1) * time.Hour,
+	}
+
+	// start the controller
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	var endpointSlices *discoveryv1.EndpointSliceList
+	//nolint: staticcheck // the wait package we are using does not have PollWithContextTimeout
+	err = wait.PollImmediate(100*time.Millisecond, 5*time.Second, func() (bool, error) {
+		endpointSlices, err = clientset.DiscoveryV1().EndpointSlices(service.Namespace).List(
+			context.Background(), metav1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		return len(endpointSlices.Items) > 0, nil
+	})
+	assert.NoError(t, err)
+
+	assert.Len(t, endpointSlices.Items, 1)
+	endpointSlice := endpointSlices.Items[0]
+	assert.Equal(t, map[string]string{
+		"kubernetes.io/service-name":             "test-service",
+		"endpointslice.kubernetes.io/managed-by": controller.EndpointSliceManagedBy,
+	}, endpointSlice.Labels)
+	assert.Equal(t, discoveryv1.AddressTypeIPv4, endpointSlice.AddressType)
+	assert.Equal(t, map[string]bool{"1.1.1.1": true, "2.2.2.2": true}, endpointReady(t, endpointSlice))
+	assert.Equal(t, "test-port", *endpointSlice.Ports[0].Name)
+	assert.Equal(t, int32(8080), *endpointSlice.Ports[0].Port)
+
+	// legacy endpoints are not written in endpoint slices mode
+	_, err = clientset.CoreV1().Endpoints(service.Namespace).Get(
+		context.Background(), service.Name, metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func TestUpdateEndpointSliceTargets(t *testing.T) {
+	// create a fake clientset
+	clientset := fake.NewSimpleClientset()
+
+	// create a new controller
+	c := controller.Controller{
+		Clientset: clientset,
+		Resync:    time.Duration(1) * time.Second,
+	}
+
+	service := newEndpointSliceTestService("endpointslices")
+	service.Annotations["endpoint-controller/targets"] = "1.1.1.1,2.2.2.2,2001:db8::1"
+
+	// create the endpoint slices with every target healthy
+	err := c.UpdateEndpointSliceTargets(context.Background(), *service, nil, []string{"1.1.1.1", "2.2.2.2", "2001:db8::1"})
+	assert.NoError(t, err)
+
+	ipv4, err := clientset.DiscoveryV1().EndpointSlices(service.Namespace).Get(
+		context.Background(), "test-service-ipv4-0", metav1.GetOptions{})
+	assert.NoError(t, err)
+	ipv6, err := clientset.DiscoveryV1().EndpointSlices(service.Namespace).Get(
+		context.Background(), "test-service-ipv6-0", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"2001:db8::1": true}, endpointReady(t, *ipv6))
+
+	// unhealthy targets stay in the endpoint slice but are not ready
+	service.Annotations["endpoint-controller/targets"] = "1.1.1.1,2.2.2.2"
+	err = c.UpdateEndpointSliceTargets(context.Background(), *service,
+		[]*discoveryv1.EndpointSlice{ipv4, ipv6}, []string{"1.1.1.1"})
+	assert.NoError(t, err)
+
+	ipv4, err = clientset.DiscoveryV1().EndpointSlices(service.Namespace).Get(
+		context.Background(), "test-service-ipv4-0", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"1.1.1.1": true, "2.2.2.2": false}, endpointReady(t, *ipv4))
+
+	// the endpoint slice of removed targets is deleted
+	_, err = clientset.DiscoveryV1().EndpointSlices(service.Namespace).Get(
+		context.Background(), "test-service-ipv6-0", metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func TestEndpointsSkipMirror(t *testing.T) {
+	// create a fake clientset
+	clientset := fake.NewSimpleClientset()
+
+	service := newEndpointSliceTestService("both")
+	_, err := clientset.CoreV1().
+		Services(service.Namespace).
+		Create(context.Background(), service, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	// create a new controller
+	c := controller.Controller{
+		Clientset: clientset,
+		Resync:    time.Duration(1) * time.Hour,
+	}
+
+	// start the controller
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	var endpoints *corev1.Endpoints
+	//nolint: staticcheck // the wait package we are using does not have PollWithContextTimeout
+	err = wait.PollImmediate(100*time.Millisecond, 5*time.Second, func() (bool, error) {
+		endpoints, err = clientset.CoreV1().Endpoints(service.Namespace).Get(
+			context.Background(), service.Name, metav1.GetOptions{})
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	})
+	assert.NoError(t, err)
+
+	// endpoints are not mirrored since the controller writes the endpoint slices
+	assert.Equal(t, "true", endpoints.Labels["endpointslice.kubernetes.io/skip-mirror"])
+
+	//nolint: staticcheck // the wait package we are using does not have PollWithContextTimeout
+	err = wait.PollImmediate(100*time.Millisecond, 5*time.Second, func() (bool, error) {
+		_, err = clientset.DiscoveryV1().EndpointSlices(service.Namespace).Get(
+			context.Background(), "test-service-ipv4-0", metav1.GetOptions{})
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestControllerReconcilesEditedEndpointSlices(t *testing.T) {
+	checker := newScriptChecker(map[string][]bool{
+		"1.1.1.1": {true},
+		"2.2.2.2": {false},
+	})
+	service := newEndpointSliceTestService("endpointslices")
+	service.Annotations["endpoint-controller/checker"] = "test"
+	clientset := fake.NewSimpleClientset(service)
+	startCheckerController(t, checker, &controller.Controller{Clientset: clientset, Resync: time.Hour})
+
+	var endpointSlice *discoveryv1.EndpointSlice
+	//nolint: staticcheck // the wait package we are using does not have PollWithContextTimeout
+	err := wait.PollImmediate(50*time.Millisecond, 5*time.Second, func() (bool, error) {
+		var err error
+		endpointSlice, err = clientset.DiscoveryV1().EndpointSlices(service.Namespace).Get(
+			context.Background(), "test-service-ipv4-0", metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+	assert.NoError(t, err)
+
+	// someone else marks the healthy target not ready before the next resync
+	notReady := false
+	endpointSlice.Endpoints[0].Conditions.Ready = &notReady
+	endpointSlice.Endpoints[0].Conditions.Serving = &notReady
+	_, err = clientset.DiscoveryV1().EndpointSlices(service.Namespace).Update(
+		context.Background(), endpointSlice, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	//nolint: staticcheck // the wait package we are using does not have PollWithContextTimeout
+	err = wait.PollImmediate(50*time.Millisecond, 5*time.Second, func() (bool, error) {
+		endpointSlice, err = clientset.DiscoveryV1().EndpointSlices(service.Namespace).Get(
+			context.Background(), "test-service-ipv4-0", metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		ready := endpointReady(t, *endpointSlice)
+		return ready["1.1.1.1"] && !ready["2.2.2.2"], nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestCreatedEndpointsAreChecked(t *testing.T) {
+	checker := newScriptChecker(map[string][]bool{
+		"1.1.1.1": {true},
+		"2.2.2.2": {false},
+	})
+	service := newEndpointSliceTestService("both")
+	service.Annotations["endpoint-controller/checker"] = "test"
+	clientset := fake.NewSimpleClientset(service)
+	c := &controller.Controller{Clientset: clientset, Resync: time.Hour}
+	startCheckerController(t, checker, c)
+
+	var endpointSlice *discoveryv1.EndpointSlice
+	//nolint: staticcheck // the wait package we are using does not have PollWithContextTimeout
+	err := wait.PollImmediate(50*time.Millisecond, 5*time.Second, func() (bool, error) {
+		var err error
+		endpointSlice, err = clientset.DiscoveryV1().EndpointSlices(service.Namespace).Get(
+			context.Background(), "test-service-ipv4-0", metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+	assert.NoError(t, err)
+
+	// the unhealthy target is left out from the start, the endpoints and endpoint slices share the check
+	assert.Equal(t, map[string]bool{"1.1.1.1": true, "2.2.2.2": false}, endpointReady(t, *endpointSlice))
+	assert.Equal(t, []string{"1.1.1.1"}, endpointTargets(t, c))
+	assert.Equal(t, 1, checker.count("2.2.2.2"))
+}
@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/archway-network/endpoint-controller/pkg/blockchain"
+)
+
+// event reasons posted on the annotated services.
+const (
+	EventReasonTargetRemoved     = "TargetRemoved"
+	EventReasonTargetRestored    = "TargetRestored"
+	EventReasonNoHealthyTargets  = "NoHealthyTargets"
+	EventReasonInvalidAnnotation = "InvalidAnnotation"
+	EventReasonValidatorRejected = "ValidatorRejected"
+	EventReasonBelowMinHealthy   = "BelowMinHealthy"
+)
+
+// InvalidAnnotationError is returned when a service annotation cannot be used.
+type InvalidAnnotationError struct {
+	Service    string
+	Annotation string
+	Message    string
+}
+
+func (e *InvalidAnnotationError) Error() string {
+	return fmt.Sprintf("%s : annotation %s %s", e.Service, e.Annotation, e.Message)
+}
+
+// event posts an event on the service when a recorder is configured.
+func (c *Controller) event(service corev1.Service, eventType, reason, messageFmt string, args ...interface{}) {
+	if c.Recorder == nil {
+		return
+	}
+	c.Recorder.Eventf(&service, eventType, reason, messageFmt, args...)
+}
+
+// recordTargetEvents
+// compares the health check results with the previous results of the service
+// posts an event for every target that was removed or restored.
+func (c *Controller) recordTargetEvents(service corev1.Service, results []blockchain.TargetHealth) {
+	key := service.Namespace + "/" + service.Name
+
+	// validators are reported on every check, routing to them would expose them to public traffic
+	for _, result := range results {
+		if result.Validator {
+			c.event(service, corev1.EventTypeWarning, EventReasonValidatorRejected,
+				"Refused to route to validator %s: %s", result.Target, result.Reason)
+		}
+	}
+
+	if len(blockchain.HealthyTargets(results)) == 0 {
+		// the endpoints are left untouched, keep the previous results
+		reasons := make([]string, 0, len(results))
+		for _, result := range results {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", result.Target, result.Reason))
+		}
+		c.event(service, corev1.EventTypeWarning, EventReasonNoHealthyTargets,
+			"No healthy targets, keeping the current endpoints (%s)", strings.Join(reasons, "; "))
+		return
+	}
+
+	c.healthMutex.Lock()
+	defer c.healthMutex.Unlock()
+	if c.targetHealth == nil {
+		c.targetHealth = map[string]map[string]bool{}
+	}
+
+	previous := c.targetHealth[key]
+	current := make(map[string]bool, len(results))
+	for _, result := range results {
+		current[result.Target] = result.Healthy
+
+		// unknown targets are listed in the existing endpoints or in the annotation, so they were healthy
+		wasHealthy, ok := previous[result.Target]
+		if !ok {
+			wasHealthy = true
+		}
+
+		switch {
+		case wasHealthy && !result.Healthy:
+			c.event(service, corev1.EventTypeWarning, EventReasonTargetRemoved,
+				"Removed target %s: %s", result.Target, result.Reason)
+		case !wasHealthy && result.Healthy:
+			c.event(service, corev1.EventTypeNormal, EventReasonTargetRestored,
+				"Restored target %s", result.Target)
+		}
+	}
+	c.targetHealth[key] = current
+}
+
+// forgetTargetHealth removes the previous results of a service that is not watched anymore.
+func (c *Controller) forgetTargetHealth(namespace, name string) {
+	c.healthMutex.Lock()
+	defer c.healthMutex.Unlock()
+	delete(c.targetHealth, namespace+"/"+name)
+}
@@ -0,0 +1,309 @@
+package controller_test
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/archway-network/endpoint-controller/pkg/blockchain"
+	"github.com/archway-network/endpoint-controller/pkg/controller"
+)
+
+// waitForEvent returns the first recorded event starting with the prefix.
+func waitForEvent(t *testing.T, recorder *record.FakeRecorder, prefix string) string {
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case event := <-recorder.Events:
+			if strings.HasPrefix(event, prefix) {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("no %s event recorded", prefix)
+			return ""
+		}
+	}
+}
+
+func TestTargetRemovedEvent(t *testing.T) {
+	// listen on 127.0.0.1 only so 127.0.0.2 refuses the connection
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	port := int32(listener.Addr().(*net.TCPAddr).Port)
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-service",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"endpoint-controller/enable":  "true",
+				"endpoint-controller/targets": "127.0.0.1,127.0.0.2",
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "test-port",
+					Port:       port,
+					TargetPort: intstr.FromInt(int(port)),
+				},
+			},
+		},
+	}
+	endpoint := &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-service",
+			Namespace: "default",
+		},
+		Subsets: []corev1.EndpointSubset{
+			{
+				Addresses: []corev1.EndpointAddress{
+					{
+						IP: "127.0.0.1",
+					},
+					{
+						IP: "127.0.0.2",
+					},
+				},
+				Ports: []corev1.EndpointPort{
+					{
+						Name: "test-port",
+						Port: port,
+					},
+				},
+			},
+		},
+	}
+
+	// create a fake clientset
+	clientset := fake.NewSimpleClientset(service, endpoint)
+	recorder := record.NewFakeRecorder(10)
+
+	// create a new controller
+	c := controller.Controller{
+		Clientset: clientset,
+		Resync:    time.Duration(1) * time.Hour,
+		Recorder:  recorder,
+	}
+
+	// start the controller
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	event := waitForEvent(t, recorder, "Warning TargetRemoved")
+	assert.Contains(t, event, "127.0.0.2")
+	assert.Contains(t, event, strconv.Itoa(int(port)))
+
+	// the unhealthy target is removed from the endpoint
+	//nolint: staticcheck // the wait package we are using does not have PollWithContextTimeout
+	err = wait.PollImmediate(100*time.Millisecond, 5*time.Second, func() (bool, error) {
+		actualEndpoint, err := clientset.CoreV1().Endpoints(
+			endpoint.Namespace).Get(context.Background(),
+			endpoint.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return len(actualEndpoint.Subsets[0].Addresses) == 1, nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestInvalidAnnotationEvent(t *testing.T) {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-service",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"endpoint-controller/enable":        "true",
+				"endpoint-controller/targets":       "1.1.1.1",
+				"endpoint-controller/endpoint-mode": "ingress",
+			},
+		},
+	}
+
+	// create a fake clientset
+	clientset := fake.NewSimpleClientset(service)
+	recorder := record.NewFakeRecorder(10)
+
+	// create a new controller
+	c := controller.Controller{
+		Clientset: clientset,
+		Resync:    time.Duration(1) * time.Hour,
+		Recorder:  recorder,
+	}
+
+	// start the controller
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	event := waitForEvent(t, recorder, "Warning InvalidAnnotation")
+	assert.Contains(t, event, "endpoint-controller/endpoint-mode")
+}
+
+// staticChecker reports fixed block heights and treats unknown targets as down.
+type staticChecker map[string]int
+
+func (s staticChecker) Check(_ context.Context, target string, _ blockchain.Config) blockchain.TargetHealth {
+	height, ok := s[target]
+	if !ok {
+		return blockchain.TargetHealth{Target: target, Reason: "target is down"}
+	}
+	return blockchain.TargetHealth{Target: target, Healthy: true, BlockHeight: height}
+}
+
+func TestCheckerAnnotation(t *testing.T) {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-service",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"endpoint-controller/enable":  "true",
+				"endpoint-controller/targets": "1.1.1.1,2.2.2.2",
+				"endpoint-controller/checker": "static",
+			},
+		},
+	}
+	endpoint := &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-service",
+			Namespace: "default",
+		},
+		Subsets: []corev1.EndpointSubset{
+			{
+				Addresses: []corev1.EndpointAddress{
+					{
+						IP: "1.1.1.1",
+					},
+					{
+						IP: "2.2.2.2",
+					},
+				},
+			},
+		},
+	}
+
+	// create a fake clientset
+	clientset := fake.NewSimpleClientset(service, endpoint)
+	recorder := record.NewFakeRecorder(10)
+
+	// register a checker that only knows the first target
+	checkers := blockchain.NewRegistry()
+	checkers.Register("static", staticChecker{"1.1.1.1": 1000})
+
+	// create a new controller
+	c := controller.Controller{
+		Clientset: clientset,
+		Resync:    time.Duration(1) * time.Hour,
+		Recorder:  recorder,
+		Checkers:  checkers,
+	}
+
+	// start the controller
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	event := waitForEvent(t, recorder, "Warning TargetRemoved")
+	assert.Equal(t, "Warning TargetRemoved Removed target 2.2.2.2: target is down", event)
+}
+
+// validatorChecker reports the targets as validators.
+type validatorChecker map[string]bool
+
+func (v validatorChecker) Check(_ context.Context, target string, _ blockchain.Config) blockchain.TargetHealth {
+	if v[target] {
+		return blockchain.TargetHealth{
+			Target:    target,
+			Validator: true,
+			Rejected:  true,
+			Reason:    "node is a validator with voting power 10",
+		}
+	}
+	return blockchain.TargetHealth{Target: target, Healthy: true}
+}
+
+func TestValidatorRejectedEvent(t *testing.T) {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-service",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"endpoint-controller/enable":            "true",
+				"endpoint-controller/targets":           "1.1.1.1,2.2.2.2",
+				"endpoint-controller/checker":           "validator",
+				"endpoint-controller/reject-validators": "true",
+			},
+		},
+	}
+	endpoint := &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-service",
+			Namespace: "default",
+		},
+		Subsets: []corev1.EndpointSubset{
+			{
+				Addresses: []corev1.EndpointAddress{
+					{
+						IP: "1.1.1.1",
+					},
+					{
+						IP: "2.2.2.2",
+					},
+				},
+			},
+		},
+	}
+
+	// create a fake clientset
+	clientset := fake.NewSimpleClientset(service, endpoint)
+	recorder := record.NewFakeRecorder(10)
+
+	checkers := blockchain.NewRegistry()
+	checkers.Register("validator", validatorChecker{"2.2.2.2": true})
+
+	// create a new controller
+	c := controller.Controller{
+		Clientset: clientset,
+		Resync:    time.Duration(1) * time.Hour,
+		Recorder:  recorder,
+		Checkers:  checkers,
+	}
+
+	// start the controller
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	event := waitForEvent(t, recorder, "Warning ValidatorRejected")
+	assert.Equal(t, "Warning ValidatorRejected Refused to route to validator 2.2.2.2: "+
+		"node is a validator with voting power 10", event)
+}
+
+func TestNoHealthyTargetsIsNotRetried(t *testing.T) {
+	checker := newScriptChecker(map[string][]bool{
+		"1.1.1.1": {false},
+		"2.2.2.2": {false},
+	})
+	recorder := runCheckerController(t, checker, []string{"1.1.1.1", "2.2.2.2"},
+		&controller.Controller{Resync: time.Hour})
+
+	waitForEvent(t, recorder, "Warning NoHealthyTargets")
+
+	// the outcome is checked again on the next resync instead of being retried
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, 1, checker.count("1.1.1.1"))
+}
@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/archway-network/endpoint-controller/pkg/blockchain"
+)
+
+const maxPercent = 100
+
+// MinHealthy is the minimum number of healthy targets of a service,
+// as a count or a percentage of the targets, the zero value has no minimum.
+type MinHealthy struct {
+	Count   int
+	Percent int
+}
+
+// ParseMinHealthy parses a count like 2 or a percentage of the targets like 50%.
+func ParseMinHealthy(value string) (MinHealthy, error) {
+	value = strings.TrimSpace(value)
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		number, err := strconv.Atoi(strings.TrimSpace(percent))
+		if err != nil || number < 0 || number > maxPercent {
+			return MinHealthy{}, fmt.Errorf("invalid percentage %q, use a number between 0%% and 100%%", value)
+		}
+		return MinHealthy{Percent: number}, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		return MinHealthy{}, fmt.Errorf("invalid count %q, use a number like 2 or a percentage like 50%%", value)
+	}
+	return MinHealthy{Count: number}, nil
+}
+
+// Floor returns the minimum number of healthy targets out of the targets,
+// percentages are rounded up and the floor never exceeds the targets.
+func (m MinHealthy) Floor(targets int) int {
+	floor := m.Count
+	if m.Percent > 0 {
+		floor = (targets*m.Percent + maxPercent - 1) / maxPercent
+	}
+	if floor > targets {
+		return targets
+	}
+	return floor
+}
+
+// minHealthyConfig holds the minimum healthy floor of a service.
+type minHealthyConfig struct {
+	minHealthy MinHealthy
+	mode       string
+}
+
+// minHealthyConfig returns the minimum healthy floor of the service,
+// the controller defaults are overridden by the annotations.
+func (c *Controller) minHealthyConfig(service corev1.Service) (minHealthyConfig, error) {
+	config := minHealthyConfig{minHealthy: c.MinHealthy, mode: c.MinHealthyMode}
+
+	if value, ok := service.Annotations[EndpointControllerMinHealthy]; ok {
+		minHealthy, err := ParseMinHealthy(value)
+		if err != nil {
+			return config, &InvalidAnnotationError{
+				Service:    service.Name,
+				Annotation: EndpointControllerMinHealthy,
+				Message:    fmt.Sprintf("has %s", err),
+			}
+		}
+		config.minHealthy = minHealthy
+	}
+
+	if value, ok := service.Annotations[EndpointControllerMinHealthyMode]; ok {
+		config.mode = strings.TrimSpace(value)
+	}
+	switch config.mode {
+	case "":
+		config.mode = MinHealthyModeKeep
+	case MinHealthyModeKeep, MinHealthyModeAll:
+	default:
+		return config, &InvalidAnnotationError{
+			Service:    service.Name,
+			Annotation: EndpointControllerMinHealthyMode,
+			Message: fmt.Sprintf("has invalid mode %q, use %s or %s",
+				config.mode, MinHealthyModeKeep, MinHealthyModeAll),
+		}
+	}
+
+	return config, nil
+}
+
+// belowMinHealthy
+// posts a BelowMinHealthy event when less targets than the floor are healthy
+// return the targets that were not rejected in the all mode
+// return no targets to keep the current endpoints in the keep mode
+// or when every target was rejected.
+func (c *Controller) belowMinHealthy(
+	service corev1.Service,
+	results []blockchain.TargetHealth,
+	healthyTargets []string,
+	floor int,
+	mode string,
+) []string {
+	c.Metrics.SetBelowMinHealthy(service.Namespace, service.Name, true)
+
+	if mode == MinHealthyModeAll {
+		// validators and nodes on another network, with another node id or on a fork stay out
+		if trusted := blockchain.TrustedTargets(results); len(trusted) > 0 {
+			c.event(service, corev1.EventTypeWarning, EventReasonBelowMinHealthy,
+				"Only %d of %d targets are healthy, below the minimum of %d, routing to the %d targets not rejected",
+				len(healthyTargets), len(results), floor, len(trusted))
+			return trusted
+		}
+	}
+
+	c.event(service, corev1.EventTypeWarning, EventReasonBelowMinHealthy,
+		"Only %d of %d targets are healthy, below the minimum of %d, keeping the current endpoints",
+		len(healthyTargets), len(results), floor)
+	return nil
+}
@@ -0,0 +1,140 @@
+package controller_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/archway-network/endpoint-controller/pkg/blockchain"
+	"github.com/archway-network/endpoint-controller/pkg/controller"
+)
+
+func TestParseMinHealthy(t *testing.T) {
+	tests := []struct {
+		value   string
+		targets int
+		floor   int
+	}{
+		{"0", 4, 0},
+		{"2", 4, 2},
+		{"5", 4, 4},
+		{"50%", 4, 2},
+		{"50%", 3, 2},
+		{"100%", 3, 3},
+		{" 25 % ", 5, 2},
+	}
+	for _, test := range tests {
+		minHealthy, err := controller.ParseMinHealthy(test.value)
+		assert.NoError(t, err, test.value)
+		assert.Equal(t, test.floor, minHealthy.Floor(test.targets), test.value)
+	}
+
+	for _, value := range []string{"", "-1", "two", "150%", "%"} {
+		_, err := controller.ParseMinHealthy(value)
+		assert.Error(t, err, value)
+	}
+}
+
+// endpointTargets returns the addresses of the endpoints of the test service.
+func endpointTargets(t *testing.T, c *controller.Controller) []string {
+	endpoint, err := c.Clientset.CoreV1().Endpoints("default").Get(
+		context.Background(), "test-service", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	var targets []string
+	for _, subset := range endpoint.Subsets {
+		for _, address := range subset.Addresses {
+			targets = append(targets, address.IP)
+		}
+	}
+	return targets
+}
+
+// newFloorChecker returns a checker with two healthy targets on the first check
+// and a single one afterwards.
+func newFloorChecker() *scriptChecker {
+	return newScriptChecker(map[string][]bool{
+		"1.1.1.1": {true},
+		"2.2.2.2": {true, false},
+		"3.3.3.3": {false},
+		"4.4.4.4": {false},
+	})
+}
+
+func TestMinHealthyKeep(t *testing.T) {
+	c := &controller.Controller{MinHealthy: controller.MinHealthy{Percent: 50}}
+	recorder := runCheckerController(t, newFloorChecker(),
+		[]string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "4.4.4.4"}, c)
+
+	event := waitForEvent(t, recorder, "Warning BelowMinHealthy")
+	assert.Equal(t, "Warning BelowMinHealthy Only 1 of 4 targets are healthy, below the minimum of 2, "+
+		"keeping the current endpoints", event)
+
+	// the targets healthy before the misfire are kept
+	assert.Equal(t, []string{"1.1.1.1", "2.2.2.2"}, endpointTargets(t, c))
+}
+
+// rejectingChecker rejects the targets of its list and checks the others with the wrapped checker.
+type rejectingChecker struct {
+	checker  blockchain.Checker
+	rejected map[string]bool
+}
+
+func (r rejectingChecker) Check(ctx context.Context, target string, config blockchain.Config) blockchain.TargetHealth {
+	if r.rejected[target] {
+		return blockchain.TargetHealth{Target: target, Rejected: true, Reason: "node is on a fork"}
+	}
+	return r.checker.Check(ctx, target, config)
+}
+
+func TestMinHealthyAll(t *testing.T) {
+	c := &controller.Controller{
+		MinHealthy:     controller.MinHealthy{Count: 2},
+		MinHealthyMode: controller.MinHealthyModeAll,
+	}
+	checker := rejectingChecker{checker: newFloorChecker(), rejected: map[string]bool{"4.4.4.4": true}}
+	recorder := runCheckerController(t, checker, []string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "4.4.4.4"}, c)
+
+	event := waitForEvent(t, recorder, "Warning BelowMinHealthy")
+	assert.Equal(t, "Warning BelowMinHealthy Only 1 of 4 targets are healthy, below the minimum of 2, "+
+		"routing to the 3 targets not rejected", event)
+
+	// every configured target but the rejected one is routed to
+	//nolint: staticcheck // the wait package we are using does not have PollWithContextTimeout
+	err := wait.PollImmediate(50*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return len(endpointTargets(t, c)) == 3, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}, endpointTargets(t, c))
+}
+
+func TestMinHealthyRecoverEvents(t *testing.T) {
+	c := &controller.Controller{MinHealthy: controller.MinHealthy{Count: 2}}
+	checker := newScriptChecker(map[string][]bool{
+		"1.1.1.1": {true},
+		"2.2.2.2": {true, false, true},
+		"3.3.3.3": {true, false},
+	})
+	recorder := runCheckerController(t, checker, []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}, c)
+
+	// the targets failing while the floor holds are reported once, before the floor event
+	assert.Equal(t, "Warning TargetRemoved Removed target 2.2.2.2: target is down", waitForEvent(t, recorder, "Warning"))
+	assert.Equal(t, "Warning TargetRemoved Removed target 3.3.3.3: target is down", waitForEvent(t, recorder, "Warning"))
+	assert.Equal(t, "Warning BelowMinHealthy Only 1 of 3 targets are healthy, below the minimum of 2, "+
+		"keeping the current endpoints", waitForEvent(t, recorder, "Warning"))
+
+	// lifting the floor restores the recovered target without reporting 3.3.3.3 again
+	assert.Equal(t, "Normal TargetRestored Restored target 2.2.2.2", waitForEvent(t, recorder, "Normal"))
+
+	//nolint: staticcheck // the wait package we are using does not have PollWithContextTimeout
+	err := wait.PollImmediate(50*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return len(endpointTargets(t, c)) == 2, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.1.1.1", "2.2.2.2"}, endpointTargets(t, c))
+	noEvent(t, recorder, "Warning")
+}
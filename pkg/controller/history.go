@@ -0,0 +1,180 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/archway-network/endpoint-controller/pkg/blockchain"
+)
+
+const (
+	// defaultHistorySize is the number of heights kept per target when the
+	// stall check does not need more.
+	defaultHistorySize = 10
+	// minRateSamples is the number of heights needed to compute a block rate.
+	minRateSamples = 3
+	// minRatePool is the number of targets with a block rate needed to compare them.
+	minRatePool = 2
+)
+
+// heightSample is the block height of a target at a health check of a resync cycle.
+type heightSample struct {
+	height int
+	time   time.Time
+	cycle  uint64
+}
+
+// historyConfig holds the height history rules of a service.
+type historyConfig struct {
+	// stallChecks marks targets unhealthy when their height did not advance
+	// for that many consecutive health checks, 0 disables the rule.
+	stallChecks int
+	// minBlockRate marks targets unhealthy when their block rate is below
+	// that fraction of the pool median, 0 disables the rule.
+	minBlockRate float64
+}
+
+// checkHeightHistory
+// adds the block heights of the results to the history of the service targets,
+// a service synced again in the same resync cycle replaces the sample of the cycle
+// sets the block rate of the targets with enough history
+// marks the targets that stalled or produce blocks far slower than the pool unhealthy.
+func (c *Controller) checkHeightHistory(
+	service corev1.Service,
+	results []blockchain.TargetHealth,
+	config historyConfig,
+	cycle uint64,
+) {
+	key := service.Namespace + "/" + service.Name
+	now := time.Now()
+
+	size := defaultHistorySize
+	if config.stallChecks+1 > size {
+		size = config.stallChecks + 1
+	}
+
+	c.historyMutex.Lock()
+	defer c.historyMutex.Unlock()
+	if c.heightHistory == nil {
+		c.heightHistory = map[string]map[string][]heightSample{}
+	}
+
+	// targets removed from the service are dropped with the previous history
+	previous := c.heightHistory[key]
+	current := make(map[string][]heightSample, len(results))
+	for i := range results {
+		history := previous[results[i].Target]
+		if results[i].BlockHeight > 0 {
+			history = addHeightSample(history, heightSample{
+				height: results[i].BlockHeight,
+				time:   now,
+				cycle:  cycle,
+			}, size)
+		}
+		current[results[i].Target] = history
+
+		results[i].BlockRate = blockRate(history)
+		if results[i].BlockRate >= 0 {
+			klog.Infof("target %s of service %s block rate %.3f blocks/s",
+				results[i].Target, service.Name, results[i].BlockRate)
+		}
+
+		if results[i].Healthy && stalled(history, config.stallChecks) {
+			results[i].Healthy = false
+			results[i].Reason = fmt.Sprintf("block height %d has not advanced for %d checks",
+				results[i].BlockHeight, config.stallChecks)
+		}
+	}
+	c.heightHistory[key] = current
+
+	if config.minBlockRate <= 0 {
+		return
+	}
+
+	median := medianBlockRate(results)
+	if median <= 0 {
+		return
+	}
+	for i := range results {
+		if !results[i].Healthy || results[i].BlockRate < 0 {
+			continue
+		}
+		if results[i].BlockRate < median*config.minBlockRate {
+			results[i].Healthy = false
+			results[i].Reason = fmt.Sprintf(
+				"block rate %.3f blocks/s is below %.2f times the pool median %.3f blocks/s",
+				results[i].BlockRate, config.minBlockRate, median)
+		}
+	}
+}
+
+// addHeightSample appends the sample to the history, keeping at most size samples,
+// the sample replaces the previous one taken in the same resync cycle,
+// the history restarts when the height went backwards, e.g. after the node was restored.
+func addHeightSample(history []heightSample, sample heightSample, size int) []heightSample {
+	if len(history) > 0 && sample.height < history[len(history)-1].height {
+		history = nil
+	}
+	if len(history) > 0 && sample.cycle == history[len(history)-1].cycle {
+		history = history[:len(history)-1]
+	}
+
+	history = append(history, sample)
+	if len(history) > size {
+		history = append([]heightSample(nil), history[len(history)-size:]...)
+	}
+	return history
+}
+
+// blockRate returns the blocks per second over the history, -1 when there is not enough history.
+func blockRate(history []heightSample) float64 {
+	if len(history) < minRateSamples {
+		return -1
+	}
+
+	first, last := history[0], history[len(history)-1]
+	elapsed := last.time.Sub(first.time).Seconds()
+	if elapsed <= 0 {
+		return -1
+	}
+	return float64(last.height-first.height) / elapsed
+}
+
+// stalled returns true when the height did not advance for the last checks.
+func stalled(history []heightSample, checks int) bool {
+	if checks <= 0 || len(history) < checks+1 {
+		return false
+	}
+
+	last := history[len(history)-1].height
+	return history[len(history)-checks-1].height == last
+}
+
+// medianBlockRate returns the median block rate of the healthy targets with a block rate,
+// 0 when less than two targets have one.
+func medianBlockRate(results []blockchain.TargetHealth) float64 {
+	var rates []float64
+	for _, result := range results {
+		if result.Healthy && result.BlockRate >= 0 {
+			rates = append(rates, result.BlockRate)
+		}
+	}
+	if len(rates) < minRatePool {
+		return 0
+	}
+
+	sort.Float64s(rates)
+	//nolint: gomnd // the middle of the sorted rates
+	return (rates[(len(rates)-1)/2] + rates[len(rates)/2]) / 2
+}
+
+// forgetHeightHistory removes the height history of a service that is not watched anymore.
+func (c *Controller) forgetHeightHistory(namespace, name string) {
+	c.historyMutex.Lock()
+	defer c.historyMutex.Unlock()
+	delete(c.heightHistory, namespace+"/"+name)
+}
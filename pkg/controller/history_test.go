@@ -0,0 +1,206 @@
+package controller_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/archway-network/endpoint-controller/pkg/blockchain"
+	"github.com/archway-network/endpoint-controller/pkg/controller"
+)
+
+// progressChecker advances the block height of every target by its step on each check.
+type progressChecker struct {
+	mutex   sync.Mutex
+	steps   map[string]int
+	heights map[string]int
+}
+
+func newProgressChecker(steps map[string]int) *progressChecker {
+	return &progressChecker{steps: steps, heights: map[string]int{}}
+}
+
+func (p *progressChecker) Check(_ context.Context, target string, _ blockchain.Config) blockchain.TargetHealth {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.heights[target] == 0 {
+		p.heights[target] = 1000
+	}
+	p.heights[target] += p.steps[target]
+	return blockchain.TargetHealth{Target: target, Healthy: true, BlockHeight: p.heights[target]}
+}
+
+// countingChecker counts the checks of the wrapped checker.
+type countingChecker struct {
+	checker blockchain.Checker
+	mutex   sync.Mutex
+	checks  int
+}
+
+func newCountingChecker(checker blockchain.Checker) *countingChecker {
+	return &countingChecker{checker: checker}
+}
+
+func (c *countingChecker) Check(ctx context.Context, target string, config blockchain.Config) blockchain.TargetHealth {
+	c.mutex.Lock()
+	c.checks++
+	c.mutex.Unlock()
+	return c.checker.Check(ctx, target, config)
+}
+
+// count returns the number of checks.
+func (c *countingChecker) count() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.checks
+}
+
+// runProgressController runs the controller on a service checked by the progress checker
+// and returns the event recorder.
+func runProgressController(t *testing.T, steps map[string]int, c *controller.Controller) *record.FakeRecorder {
+	targets := make([]string, 0, len(steps))
+	for target := range steps {
+		targets = append(targets, target)
+	}
+	return runCheckerController(t, newProgressChecker(steps), targets, c)
+}
+
+// runCheckerController runs the controller on a service whose targets are checked
+// by the checker every 50ms unless the controller sets its resync, and returns the event recorder.
+func runCheckerController(
+	t *testing.T,
+	checker blockchain.Checker,
+	targets []string,
+	c *controller.Controller,
+) *record.FakeRecorder {
+	service, endpoint := newCheckerObjects(targets)
+	c.Clientset = fake.NewSimpleClientset(service, endpoint)
+	return startCheckerController(t, checker, c)
+}
+
+// runRetryingController runs the controller on a service whose endpoints miss the second
+// of two targets and cannot be updated, so every sync fails and is retried until the hourly resync.
+func runRetryingController(t *testing.T, checker blockchain.Checker, c *controller.Controller) *record.FakeRecorder {
+	service, endpoint := newCheckerObjects([]string{"1.1.1.1", "2.2.2.2"})
+	endpoint.Subsets[0].Addresses = endpoint.Subsets[0].Addresses[:1]
+
+	clientset := fake.NewSimpleClientset(service, endpoint)
+	clientset.PrependReactor("update", "endpoints", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("the endpoints are read only")
+	})
+	c.Clientset = clientset
+	c.Resync = time.Hour
+	return startCheckerController(t, checker, c)
+}
+
+// newCheckerObjects returns a service checked by the test checker and its endpoints holding every target.
+func newCheckerObjects(targets []string) (*corev1.Service, *corev1.Endpoints) {
+	addresses := make([]corev1.EndpointAddress, 0, len(targets))
+	for _, target := range targets {
+		addresses = append(addresses, corev1.EndpointAddress{IP: target})
+	}
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-service",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"endpoint-controller/enable":  "true",
+				"endpoint-controller/targets": strings.Join(targets, ","),
+				"endpoint-controller/checker": "test",
+			},
+		},
+	}
+	endpoint := &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-service",
+			Namespace: "default",
+		},
+		Subsets: []corev1.EndpointSubset{
+			{
+				Addresses: addresses,
+			},
+		},
+	}
+	return service, endpoint
+}
+
+// startCheckerController runs the controller with the checker registered as test
+// and returns the event recorder.
+func startCheckerController(
+	t *testing.T,
+	checker blockchain.Checker,
+	c *controller.Controller,
+) *record.FakeRecorder {
+	recorder := record.NewFakeRecorder(100)
+	checkers := blockchain.NewRegistry()
+	checkers.Register("test", checker)
+
+	if c.Resync == 0 {
+		c.Resync = 50 * time.Millisecond
+	}
+	c.BlockMiss = 1000000
+	c.Recorder = recorder
+	c.Checkers = checkers
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go c.Run(ctx)
+	return recorder
+}
+
+func TestStalledTarget(t *testing.T) {
+	recorder := runProgressController(t, map[string]int{
+		"1.1.1.1": 0,
+		"2.2.2.2": 1,
+	}, &controller.Controller{StallChecks: 2})
+
+	event := waitForEvent(t, recorder, "Warning")
+	assert.Equal(t, "Warning TargetRemoved Removed target 1.1.1.1: block height 1000 has not advanced for 2 checks", event)
+}
+
+func TestSlowBlockRate(t *testing.T) {
+	recorder := runProgressController(t, map[string]int{
+		"1.1.1.1": 1,
+		"2.2.2.2": 10,
+		"3.3.3.3": 10,
+	}, &controller.Controller{MinBlockRate: 0.5})
+
+	event := waitForEvent(t, recorder, "Warning")
+	assert.True(t, strings.HasPrefix(event, "Warning TargetRemoved Removed target 1.1.1.1: block rate "), event)
+	assert.Contains(t, event, "is below 0.50 times the pool median")
+}
+
+// noEvent fails when an event starting with the prefix was recorded.
+func noEvent(t *testing.T, recorder *record.FakeRecorder, prefix string) {
+	for {
+		select {
+		case event := <-recorder.Events:
+			assert.False(t, strings.HasPrefix(event, prefix), event)
+		default:
+			return
+		}
+	}
+}
+
+func TestRetriesDoNotStallTargets(t *testing.T) {
+	checker := newCountingChecker(staticChecker{"1.1.1.1": 1000, "2.2.2.2": 1000})
+	recorder := runRetryingController(t, checker, &controller.Controller{StallChecks: 2})
+
+	// the failed syncs are retried within the first resync cycle, so the height did not
+	// stay the same for 2 checks yet
+	time.Sleep(time.Second)
+	assert.Greater(t, checker.count(), 3)
+	noEvent(t, recorder, "Warning")
+}
@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/archway-network/endpoint-controller/pkg/blockchain"
+)
+
+// targetCounter holds whether a target is in the endpoints, the consecutive checks
+// it passed or failed and the resync cycle of the last counted check.
+type targetCounter struct {
+	member bool
+	passed int
+	failed int
+	cycle  uint64
+}
+
+// hysteresisConfig holds the rise and fall thresholds of a service.
+type hysteresisConfig struct {
+	// rise is the number of consecutive checks a removed target must pass to be restored.
+	rise int
+	// fall is the number of consecutive checks a target must fail to be removed.
+	fall int
+}
+
+// applyHysteresis
+// counts the consecutive checks every target passed or failed, once per resync cycle
+// keeps the failing targets until they failed fall checks in a row
+// keeps the removed targets out until they passed rise checks in a row.
+func (c *Controller) applyHysteresis(
+	service corev1.Service,
+	results []blockchain.TargetHealth,
+	config hysteresisConfig,
+	cycle uint64,
+) {
+	key := service.Namespace + "/" + service.Name
+
+	c.counterMutex.Lock()
+	defer c.counterMutex.Unlock()
+	if c.targetCounters == nil {
+		c.targetCounters = map[string]map[string]targetCounter{}
+	}
+
+	// targets removed from the service are dropped with the previous counters
+	previous := c.targetCounters[key]
+	current := make(map[string]targetCounter, len(results))
+	for i := range results {
+		// unknown targets are listed in the existing endpoints or in the annotation, so they are members
+		counter, ok := previous[results[i].Target]
+		if !ok {
+			counter.member = true
+		}
+
+		// a service synced again in the same cycle, e.g. after a failed update,
+		// keeps the membership decided by the counted checks
+		if !ok || counter.cycle != cycle {
+			if results[i].Healthy {
+				counter.passed++
+				counter.failed = 0
+			} else {
+				counter.failed++
+				counter.passed = 0
+			}
+			counter.cycle = cycle
+		}
+
+		switch {
+		case results[i].Rejected:
+			// rejected targets, e.g. validators or nodes on another network, are removed on the first check
+			counter.member = false
+		case counter.member && !results[i].Healthy:
+			if counter.failed < config.fall {
+				klog.Infof("target %s of service %s failed %d of %d checks, keeping it: %s",
+					results[i].Target, service.Name, counter.failed, config.fall, results[i].Reason)
+				results[i].Healthy = true
+				results[i].Reason = ""
+			} else {
+				counter.member = false
+			}
+		case !counter.member && results[i].Healthy:
+			if counter.passed < config.rise {
+				results[i].Healthy = false
+				results[i].Reason = fmt.Sprintf("passed %d of the %d consecutive checks needed to be restored",
+					counter.passed, config.rise)
+			} else {
+				counter.member = true
+			}
+		}
+		current[results[i].Target] = counter
+	}
+	c.targetCounters[key] = current
+}
+
+// forgetTargetCounters removes the check counters of a service that is not watched anymore.
+func (c *Controller) forgetTargetCounters(namespace, name string) {
+	c.counterMutex.Lock()
+	defer c.counterMutex.Unlock()
+	delete(c.targetCounters, namespace+"/"+name)
+}
@@ -0,0 +1,103 @@
+package controller_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/archway-network/endpoint-controller/pkg/blockchain"
+	"github.com/archway-network/endpoint-controller/pkg/controller"
+)
+
+// scriptChecker reports the health of every target from its script, one entry per check,
+// the last entry is repeated once the script is over.
+type scriptChecker struct {
+	mutex  sync.Mutex
+	script map[string][]bool
+	checks map[string]int
+}
+
+func newScriptChecker(script map[string][]bool) *scriptChecker {
+	return &scriptChecker{script: script, checks: map[string]int{}}
+}
+
+func (s *scriptChecker) Check(_ context.Context, target string, _ blockchain.Config) blockchain.TargetHealth {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	script := s.script[target]
+	healthy := script[len(script)-1]
+	if s.checks[target] < len(script) {
+		healthy = script[s.checks[target]]
+	}
+	s.checks[target]++
+
+	if !healthy {
+		return blockchain.TargetHealth{Target: target, Reason: "target is down"}
+	}
+	return blockchain.TargetHealth{Target: target, Healthy: true}
+}
+
+// count returns the number of checks of the target.
+func (s *scriptChecker) count(target string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.checks[target]
+}
+
+func TestFallChecks(t *testing.T) {
+	checker := newScriptChecker(map[string][]bool{
+		"1.1.1.1": {true, false, true, false, false, false},
+		"2.2.2.2": {true},
+	})
+	recorder := runCheckerController(t, checker, []string{"1.1.1.1", "2.2.2.2"}, &controller.Controller{Fall: 3})
+
+	// single failures do not remove the target, the third failure in a row does
+	event := waitForEvent(t, recorder, "Warning")
+	assert.Equal(t, "Warning TargetRemoved Removed target 1.1.1.1: target is down", event)
+	assert.GreaterOrEqual(t, checker.count("1.1.1.1"), 6)
+}
+
+func TestRiseChecks(t *testing.T) {
+	checker := newScriptChecker(map[string][]bool{
+		"1.1.1.1": {false, true, false, true, true, true},
+		"2.2.2.2": {true},
+	})
+	recorder := runCheckerController(t, checker, []string{"1.1.1.1", "2.2.2.2"}, &controller.Controller{Rise: 3})
+
+	event := waitForEvent(t, recorder, "Warning")
+	assert.Equal(t, "Warning TargetRemoved Removed target 1.1.1.1: target is down", event)
+
+	// the target is restored after three successful checks in a row
+	event = waitForEvent(t, recorder, "Normal")
+	assert.Equal(t, "Normal TargetRestored Restored target 1.1.1.1", event)
+	assert.GreaterOrEqual(t, checker.count("1.1.1.1"), 6)
+}
+
+func TestRetriesDoNotAdvanceCounters(t *testing.T) {
+	checker := newScriptChecker(map[string][]bool{
+		"1.1.1.1": {true},
+		"2.2.2.2": {false},
+	})
+	recorder := runRetryingController(t, checker, &controller.Controller{Fall: 3})
+
+	// the failed syncs are retried within the first resync cycle, which counts as a single check
+	time.Sleep(time.Second)
+	assert.Greater(t, checker.count("2.2.2.2"), 3)
+	noEvent(t, recorder, "Warning")
+}
+
+func TestRejectedTargetsSkipFallChecks(t *testing.T) {
+	checker := rejectingChecker{
+		checker:  newScriptChecker(map[string][]bool{"1.1.1.1": {true}}),
+		rejected: map[string]bool{"2.2.2.2": true},
+	}
+	// the hourly resync leaves a single check, fewer than the fall checks
+	recorder := runCheckerController(t, checker, []string{"1.1.1.1", "2.2.2.2"},
+		&controller.Controller{Fall: 3, Resync: time.Hour})
+
+	event := waitForEvent(t, recorder, "Warning")
+	assert.Equal(t, "Warning TargetRemoved Removed target 2.2.2.2: node is on a fork", event)
+}
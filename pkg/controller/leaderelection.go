@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+// LeaderElectionConfig defines the Lease used to elect the active controller.
+type LeaderElectionConfig struct {
+	LeaseName      string
+	LeaseNamespace string
+	Identity       string
+	LeaseDuration  time.Duration
+	RenewDeadline  time.Duration
+	RetryPeriod    time.Duration
+}
+
+// runWithLeaderElection blocks until the Lease is acquired and reconciles
+// the endpoints for as long as this replica stays the leader,
+// the Lease is released once the controller stopped.
+func (c *Controller) runWithLeaderElection(ctx context.Context) {
+	config := c.LeaderElection
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: v1.ObjectMeta{
+			Name:      config.LeaseName,
+			Namespace: config.LeaseNamespace,
+		},
+		Client: c.Clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: config.Identity,
+		},
+	}
+
+	klog.Infof("Waiting for leadership of lease %s/%s as %s",
+		config.LeaseNamespace, config.LeaseName, config.Identity)
+
+	// the election outlives ctx so the Lease is only released
+	// after the reconciles in flight finished
+	electionCtx, cancelElection := context.WithCancel(context.Background())
+	defer cancelElection()
+	leading := make(chan context.Context, 1)
+	elected := make(chan struct{})
+
+	go func() {
+		defer close(elected)
+		leaderelection.RunOrDie(electionCtx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			ReleaseOnCancel: true,
+			LeaseDuration:   config.LeaseDuration,
+			RenewDeadline:   config.RenewDeadline,
+			RetryPeriod:     config.RetryPeriod,
+			Name:            config.LeaseName,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(leaderCtx context.Context) {
+					klog.Infof("Acquired leadership as %s", config.Identity)
+					leading <- leaderCtx
+				},
+				OnStoppedLeading: func() {
+					if ctx.Err() != nil {
+						klog.Infof("Stopped the leader election as %s", config.Identity)
+						return
+					}
+					// a standby replica takes over, restart to become a standby ourselves
+					klog.Fatalf("Lost leadership as %s", config.Identity)
+				},
+				OnNewLeader: func(identity string) {
+					if identity != config.Identity {
+						klog.Infof("Current leader is %s", identity)
+					}
+				},
+			},
+		})
+	}()
+
+	select {
+	case leaderCtx := <-leading:
+		// stop on shutdown or when the leadership is lost
+		runCtx, cancel := context.WithCancel(leaderCtx)
+		go func() {
+			select {
+			case <-ctx.Done():
+				cancel()
+			case <-runCtx.Done():
+			}
+		}()
+		c.run(runCtx)
+		cancel()
+	case <-ctx.Done():
+	}
+
+	cancelElection()
+	<-elected
+}
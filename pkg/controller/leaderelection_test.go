@@ -0,0 +1,152 @@
+package controller_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/archway-network/endpoint-controller/pkg/controller"
+)
+
+// createLeaseTestObjects creates an annotated service and a lease held by another replica.
+func createLeaseTestObjects(t *testing.T, clientset *fake.Clientset, leaseDuration time.Duration) {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-service",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"endpoint-controller/enable":  "true",
+				"endpoint-controller/targets": "1.1.1.1",
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "test-port",
+					Port:       8080,
+					TargetPort: intstr.FromInt(8080),
+				},
+			},
+		},
+	}
+	_, err := clientset.CoreV1().
+		Services(service.Namespace).
+		Create(context.Background(), service, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	holder := "other-replica"
+	leaseDurationSeconds := int32(leaseDuration.Seconds())
+	now := metav1.NewMicroTime(time.Now())
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "endpoint-controller",
+			Namespace: "default",
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &leaseDurationSeconds,
+			AcquireTime:          &now,
+			RenewTime:            &now,
+		},
+	}
+	_, err = clientset.CoordinationV1().
+		Leases(lease.Namespace).
+		Create(context.Background(), lease, metav1.CreateOptions{})
+	assert.NoError(t, err)
+}
+
+// endpointsCreated checks whether the controller created the test endpoints.
+func endpointsCreated(clientset *fake.Clientset) (bool, error) {
+	_, err := clientset.CoreV1().Endpoints("default").Get(
+		context.Background(), "test-service", metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func TestLeaderElectionStandby(t *testing.T) {
+	// create a fake clientset with a lease held by a healthy leader
+	clientset := fake.NewSimpleClientset()
+	createLeaseTestObjects(t, clientset, time.Minute)
+
+	// create a new controller
+	c := controller.Controller{
+		Clientset: clientset,
+		Resync:    time.Duration(1) * time.Second,
+		LeaderElection: &controller.LeaderElectionConfig{
+			LeaseName:      "endpoint-controller",
+			LeaseNamespace: "default",
+			Identity:       "standby-replica",
+			LeaseDuration:  time.Minute,
+			RenewDeadline:  time.Duration(30) * time.Second,
+			RetryPeriod:    time.Duration(100) * time.Millisecond,
+		},
+	}
+
+	// start the controller
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	// the standby must not reconcile while the lease is held
+	//nolint: staticcheck // the wait package we are using does not have PollWithContextTimeout
+	err := wait.PollImmediate(100*time.Millisecond, 2*time.Second, func() (bool, error) {
+		return endpointsCreated(clientset)
+	})
+	assert.ErrorIs(t, err, wait.ErrWaitTimeout)
+
+	lease, err := clientset.CoordinationV1().Leases("default").Get(
+		context.Background(), "endpoint-controller", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "other-replica", *lease.Spec.HolderIdentity)
+}
+
+func TestLeaderElectionTakeover(t *testing.T) {
+	// create a fake clientset with a lease held by a leader that stopped renewing
+	clientset := fake.NewSimpleClientset()
+	createLeaseTestObjects(t, clientset, time.Second)
+
+	// create a new controller
+	c := controller.Controller{
+		Clientset: clientset,
+		Resync:    time.Duration(1) * time.Second,
+		LeaderElection: &controller.LeaderElectionConfig{
+			LeaseName:      "endpoint-controller",
+			LeaseNamespace: "default",
+			Identity:       "standby-replica",
+			LeaseDuration:  time.Second,
+			RenewDeadline:  time.Duration(500) * time.Millisecond,
+			RetryPeriod:    time.Duration(100) * time.Millisecond,
+		},
+	}
+
+	// start the controller
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	// the standby takes over the expired lease and reconciles
+	//nolint: staticcheck // the wait package we are using does not have PollWithContextTimeout
+	err := wait.PollImmediate(100*time.Millisecond, 6*time.Second, func() (bool, error) {
+		return endpointsCreated(clientset)
+	})
+	assert.NoError(t, err)
+
+	lease, err := clientset.CoordinationV1().Leases("default").Get(
+		context.Background(), "endpoint-controller", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "standby-replica", *lease.Spec.HolderIdentity)
+}
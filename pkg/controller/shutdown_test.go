@@ -0,0 +1,96 @@
+package controller_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/archway-network/endpoint-controller/pkg/blockchain"
+	"github.com/archway-network/endpoint-controller/pkg/controller"
+)
+
+// slowChecker takes delay to check a target unless its context is canceled first,
+// the error of the context of the first check is sent on done when it returns.
+type slowChecker struct {
+	delay   time.Duration
+	started chan struct{}
+	done    chan error
+}
+
+func (s slowChecker) Check(ctx context.Context, target string, _ blockchain.Config) blockchain.TargetHealth {
+	select {
+	case s.started <- struct{}{}:
+	default:
+	}
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+	}
+	select {
+	case s.done <- ctx.Err():
+	default:
+	}
+	return blockchain.TargetHealth{Target: target, Healthy: true}
+}
+
+// runSlowController starts a controller checking a single target with the slow checker,
+// it cancels the controller once the check started and returns how long Run took to return.
+func runSlowController(t *testing.T, checker slowChecker, gracePeriod time.Duration) time.Duration {
+	service, endpoint := newStatusTestObjects(26657, []string{"1.1.1.1"}, map[string]string{
+		"endpoint-controller/checker": "slow",
+	})
+
+	checkers := blockchain.NewRegistry()
+	checkers.Register("slow", checker)
+
+	c := controller.Controller{
+		Clientset:           fake.NewSimpleClientset(service, endpoint),
+		Resync:              time.Hour,
+		Checkers:            checkers,
+		ShutdownGracePeriod: gracePeriod,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(stopped)
+	}()
+
+	select {
+	case <-checker.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the target was not checked")
+	}
+
+	cancel()
+	start := time.Now()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return")
+	}
+	return time.Since(start)
+}
+
+func TestShutdownFinishesInFlightChecks(t *testing.T) {
+	checker := slowChecker{delay: 200 * time.Millisecond, started: make(chan struct{}, 1), done: make(chan error, 1)}
+
+	runSlowController(t, checker, 5*time.Second)
+
+	// the check finished without being canceled
+	assert.NoError(t, <-checker.done)
+}
+
+func TestShutdownAbortsAfterGracePeriod(t *testing.T) {
+	checker := slowChecker{delay: time.Minute, started: make(chan struct{}, 1), done: make(chan error, 1)}
+
+	elapsed := runSlowController(t, checker, 100*time.Millisecond)
+
+	assert.ErrorIs(t, <-checker.done, context.Canceled)
+	assert.Less(t, elapsed, 2*time.Second)
+}
@@ -0,0 +1,273 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "endpoint_controller"
+
+	readHeaderTimeout = 5
+)
+
+// Metrics holds the Prometheus metrics of the controller.
+// All methods are safe to call on a nil *Metrics.
+type Metrics struct {
+	reconcileDuration *prometheus.HistogramVec
+	reconcileErrors   *prometheus.CounterVec
+	apiErrors         *prometheus.CounterVec
+	targetsConfigured *prometheus.GaugeVec
+	targetsHealthy    *prometheus.GaugeVec
+	belowMinHealthy   *prometheus.GaugeVec
+	targetHealthy     *prometheus.GaugeVec
+	blockHeight       *prometheus.GaugeVec
+	blockLag          *prometheus.GaugeVec
+	blockAge          *prometheus.GaugeVec
+	blockRate         *prometheus.GaugeVec
+	portCheckDuration *prometheus.HistogramVec
+	portCheckFailures *prometheus.CounterVec
+}
+
+// New creates the controller metrics and registers them.
+func New(registerer prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		reconcileDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "reconcile_duration_seconds",
+			Help:      "Duration of the service reconciliations.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+		}, []string{"namespace", "service"}),
+		reconcileErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "reconcile_errors_total",
+			Help:      "Number of failed service reconciliations.",
+		}, []string{"namespace", "service"}),
+		apiErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "kubernetes_api_errors_total",
+			Help:      "Number of failed Kubernetes API calls.",
+		}, []string{"resource", "verb"}),
+		targetsConfigured: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "targets_configured",
+			Help:      "Number of targets configured on the service.",
+		}, []string{"namespace", "service"}),
+		targetsHealthy: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "targets_healthy",
+			Help:      "Number of healthy targets of the service.",
+		}, []string{"namespace", "service"}),
+		belowMinHealthy: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "below_min_healthy",
+			Help:      "Whether less targets of the service than its minimum are healthy.",
+		}, []string{"namespace", "service"}),
+		targetHealthy: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "target_healthy",
+			Help:      "Whether the target passed the health check.",
+		}, []string{"namespace", "service", "target"}),
+		blockHeight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "target_block_height",
+			Help:      "Latest block height reported by the target.",
+		}, []string{"namespace", "service", "target"}),
+		blockLag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "target_block_lag",
+			Help:      "Number of blocks the target is behind the reference height of the service, negative when ahead.",
+		}, []string{"namespace", "service", "target"}),
+		blockAge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "target_block_age_seconds",
+			Help:      "Age of the latest block reported by the target.",
+		}, []string{"namespace", "service", "target"}),
+		blockRate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "target_block_rate",
+			Help:      "Blocks per second produced by the target over the previous health checks.",
+		}, []string{"namespace", "service", "target"}),
+		portCheckDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "port_check_duration_seconds",
+			Help:      "Duration of the TCP port checks.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+		}, []string{"namespace", "service", "target", "port"}),
+		portCheckFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "port_check_failures_total",
+			Help:      "Number of failed TCP port checks.",
+		}, []string{"namespace", "service", "target", "port"}),
+	}
+
+	registerer.MustRegister(
+		m.reconcileDuration,
+		m.reconcileErrors,
+		m.apiErrors,
+		m.targetsConfigured,
+		m.targetsHealthy,
+		m.belowMinHealthy,
+		m.targetHealthy,
+		m.blockHeight,
+		m.blockLag,
+		m.blockAge,
+		m.blockRate,
+		m.portCheckDuration,
+		m.portCheckFailures,
+	)
+
+	return m
+}
+
+// ObserveReconcile records the duration and result of a service reconciliation.
+func (m *Metrics) ObserveReconcile(namespace, service string, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	m.reconcileDuration.WithLabelValues(namespace, service).Observe(duration.Seconds())
+	if err != nil {
+		m.reconcileErrors.WithLabelValues(namespace, service).Inc()
+	}
+}
+
+// APIError records a failed Kubernetes API call.
+func (m *Metrics) APIError(resource, verb string) {
+	if m == nil {
+		return
+	}
+
+	m.apiErrors.WithLabelValues(resource, verb).Inc()
+}
+
+// SetTargets records the number of configured and healthy targets of a service.
+func (m *Metrics) SetTargets(namespace, service string, configured, healthy int) {
+	if m == nil {
+		return
+	}
+
+	m.targetsConfigured.WithLabelValues(namespace, service).Set(float64(configured))
+	m.targetsHealthy.WithLabelValues(namespace, service).Set(float64(healthy))
+}
+
+// SetBelowMinHealthy records whether less targets of a service than its minimum are healthy.
+func (m *Metrics) SetBelowMinHealthy(namespace, service string, below bool) {
+	if m == nil {
+		return
+	}
+
+	value := 0.0
+	if below {
+		value = 1
+	}
+	m.belowMinHealthy.WithLabelValues(namespace, service).Set(value)
+}
+
+// ResetTargets removes the per target gauges of a service,
+// so targets removed from the service stop being reported.
+func (m *Metrics) ResetTargets(namespace, service string) {
+	if m == nil {
+		return
+	}
+
+	labels := prometheus.Labels{"namespace": namespace, "service": service}
+	m.targetHealthy.DeletePartialMatch(labels)
+	m.blockHeight.DeletePartialMatch(labels)
+	m.blockLag.DeletePartialMatch(labels)
+	m.blockAge.DeletePartialMatch(labels)
+	m.blockRate.DeletePartialMatch(labels)
+}
+
+// SetTargetHealth records the health check result of a target.
+func (m *Metrics) SetTargetHealth(namespace, service, target string, healthy bool) {
+	if m == nil {
+		return
+	}
+
+	value := 0.0
+	if healthy {
+		value = 1
+	}
+	m.targetHealthy.WithLabelValues(namespace, service, target).Set(value)
+}
+
+// SetBlockHeight records the latest block height of a target and its lag behind the reference height.
+func (m *Metrics) SetBlockHeight(namespace, service, target string, height, lag int) {
+	if m == nil {
+		return
+	}
+
+	m.blockHeight.WithLabelValues(namespace, service, target).Set(float64(height))
+	m.blockLag.WithLabelValues(namespace, service, target).Set(float64(lag))
+}
+
+// SetBlockAge records the age of the latest block of a target.
+func (m *Metrics) SetBlockAge(namespace, service, target string, age time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.blockAge.WithLabelValues(namespace, service, target).Set(age.Seconds())
+}
+
+// SetBlockRate records the blocks per second produced by a target.
+func (m *Metrics) SetBlockRate(namespace, service, target string, rate float64) {
+	if m == nil {
+		return
+	}
+
+	m.blockRate.WithLabelValues(namespace, service, target).Set(rate)
+}
+
+// ObservePortCheck records the duration and result of a TCP port check.
+func (m *Metrics) ObservePortCheck(
+	namespace, service, target string,
+	port int32,
+	duration time.Duration,
+	err error,
+) {
+	if m == nil {
+		return
+	}
+
+	portLabel := strconv.Itoa(int(port))
+	m.portCheckDuration.WithLabelValues(namespace, service, target, portLabel).Observe(duration.Seconds())
+	if err != nil {
+		m.portCheckFailures.WithLabelValues(namespace, service, target, portLabel).Inc()
+	}
+}
+
+// DeleteService removes every metric of a service that is not watched anymore.
+func (m *Metrics) DeleteService(namespace, service string) {
+	if m == nil {
+		return
+	}
+
+	labels := prometheus.Labels{"namespace": namespace, "service": service}
+	m.reconcileDuration.DeletePartialMatch(labels)
+	m.reconcileErrors.DeletePartialMatch(labels)
+	m.targetsConfigured.DeletePartialMatch(labels)
+	m.targetsHealthy.DeletePartialMatch(labels)
+	m.belowMinHealthy.DeletePartialMatch(labels)
+	m.portCheckDuration.DeletePartialMatch(labels)
+	m.portCheckFailures.DeletePartialMatch(labels)
+	m.ResetTargets(namespace, service)
+}
+
+// ListenAndServe serves the metrics of the gatherer on /metrics.
+func ListenAndServe(addr string, gatherer prometheus.Gatherer) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout * time.Second,
+	}
+	return server.ListenAndServe()
+}
@@ -0,0 +1,84 @@
+package metrics_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/archway-network/endpoint-controller/pkg/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m := metrics.New(registry)
+
+	m.ObserveReconcile("default", "test-service", time.Second, errors.New("no healthy targets"))
+	m.SetTargets("default", "test-service", 3, 2)
+	m.SetBelowMinHealthy("default", "test-service", true)
+	m.SetTargetHealth("default", "test-service", "1.1.1.1", true)
+	m.SetBlockHeight("default", "test-service", "1.1.1.1", 1000, 2)
+	m.SetBlockAge("default", "test-service", "1.1.1.1", 6*time.Second)
+	m.SetBlockRate("default", "test-service", "1.1.1.1", 0.25)
+	m.ObservePortCheck("default", "test-service", "2.2.2.2", 26657, time.Second, errors.New("timeout"))
+
+	expected := `
+# HELP endpoint_controller_below_min_healthy Whether less targets of the service than its minimum are healthy.
+# TYPE endpoint_controller_below_min_healthy gauge
+endpoint_controller_below_min_healthy{namespace="default",service="test-service"} 1
+# HELP endpoint_controller_reconcile_errors_total Number of failed service reconciliations.
+# TYPE endpoint_controller_reconcile_errors_total counter
+endpoint_controller_reconcile_errors_total{namespace="default",service="test-service"} 1
+# HELP endpoint_controller_target_block_age_seconds Age of the latest block reported by the target.
+# TYPE endpoint_controller_target_block_age_seconds gauge
+endpoint_controller_target_block_age_seconds{namespace="default",service="test-service",target="1.1.1.1"} 6
+# HELP endpoint_controller_target_block_height Latest block height reported by the target.
+# TYPE endpoint_controller_target_block_height gauge
+endpoint_controller_target_block_height{namespace="default",service="test-service",target="1.1.1.1"} 1000
+` + "# HELP endpoint_controller_target_block_lag Number of blocks the target is behind the reference height " +
+		"of the service, negative when ahead.\n" + `# TYPE endpoint_controller_target_block_lag gauge
+endpoint_controller_target_block_lag{namespace="default",service="test-service",target="1.1.1.1"} 2
+# HELP endpoint_controller_target_block_rate Blocks per second produced by the target over the previous health checks.
+# TYPE endpoint_controller_target_block_rate gauge
+endpoint_controller_target_block_rate{namespace="default",service="test-service",target="1.1.1.1"} 0.25
+# HELP endpoint_controller_targets_healthy Number of healthy targets of the service.
+# TYPE endpoint_controller_targets_healthy gauge
+endpoint_controller_targets_healthy{namespace="default",service="test-service"} 2
+# HELP endpoint_controller_port_check_failures_total Number of failed TCP port checks.
+# TYPE endpoint_controller_port_check_failures_total counter
+` + "endpoint_controller_port_check_failures_total" +
+		`{namespace="default",port="26657",service="test-service",target="2.2.2.2"} 1
+`
+	err := testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"endpoint_controller_below_min_healthy",
+		"endpoint_controller_reconcile_errors_total",
+		"endpoint_controller_target_block_age_seconds",
+		"endpoint_controller_target_block_height",
+		"endpoint_controller_target_block_lag",
+		"endpoint_controller_target_block_rate",
+		"endpoint_controller_targets_healthy",
+		"endpoint_controller_port_check_failures_total",
+	)
+	assert.NoError(t, err)
+
+	// removed services stop being reported
+	m.DeleteService("default", "test-service")
+	count, err := testutil.GatherAndCount(registry)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *metrics.Metrics
+
+	assert.NotPanics(t, func() {
+		m.ObserveReconcile("default", "test-service", time.Second, nil)
+		m.APIError("endpoints", "update")
+		m.SetTargets("default", "test-service", 3, 2)
+		m.DeleteService("default", "test-service")
+	})
+}
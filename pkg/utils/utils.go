@@ -33,3 +33,21 @@ func GetEnv(name, defaultValue string) (int, error) {
 
 	return envVar, nil
 }
+
+func GetEnvString(name, defaultValue string) string {
+	envVarString := os.Getenv(name)
+	if envVarString == "" {
+		return defaultValue
+	}
+
+	return envVarString
+}
+
+func GetEnvBool(name string, defaultValue bool) (bool, error) {
+	envVarString := os.Getenv(name)
+	if envVarString == "" {
+		return defaultValue, nil
+	}
+
+	return strconv.ParseBool(envVarString)
+}
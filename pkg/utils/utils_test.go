@@ -19,3 +19,18 @@ func TestRemoveFromSlice(t *testing.T) {
 		assert.Equal(t, v, utils.RemoveFromSlice(data, k))
 	}
 }
+
+func TestGetEnvBool(t *testing.T) {
+	value, err := utils.GetEnvBool("TEST_GET_ENV_BOOL", true)
+	assert.NoError(t, err)
+	assert.True(t, value)
+
+	t.Setenv("TEST_GET_ENV_BOOL", "false")
+	value, err = utils.GetEnvBool("TEST_GET_ENV_BOOL", true)
+	assert.NoError(t, err)
+	assert.False(t, value)
+
+	t.Setenv("TEST_GET_ENV_BOOL", "maybe")
+	_, err = utils.GetEnvBool("TEST_GET_ENV_BOOL", true)
+	assert.Error(t, err)
+}